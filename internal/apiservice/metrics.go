@@ -0,0 +1,23 @@
+package apiservice
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	pollDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "gophstream_relay_poll_duration_seconds",
+		Help:    "Time of a relay tick, from acknowledging the previous results to handing the claimed records to the publisher.",
+		Buckets: prometheus.DefBuckets,
+	})
+	pollBatchSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "gophstream_relay_batch_size",
+		Help:    "Outbox records claimed per relay tick.",
+		Buckets: []float64{0, 1, 5, 10, 25, 50, 100, 250, 500, 1000},
+	})
+	outboxResults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gophstream_relay_results_total",
+		Help: "Outbox records acknowledged per tick by result (delivered, failed).",
+	}, []string{"result"})
+)
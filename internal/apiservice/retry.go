@@ -0,0 +1,72 @@
+package apiservice
+
+import (
+	"math/rand"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// RetryPolicy decides when a message that failed to publish is sent again
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// NewRetryPolicy creates a RetryPolicy from the configuration options
+func NewRetryPolicy(maxAttempts, baseDelay, maxDelay func() string, log Log) RetryPolicy {
+	policy := RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   time.Second,
+		MaxDelay:    5 * time.Minute,
+	}
+
+	if v, err := strconv.Atoi(maxAttempts()); err != nil || v < 1 {
+		log.Info("cannot convert retry option 'RetryMaxAttempts': ", zap.String("value", maxAttempts()))
+	} else {
+		policy.MaxAttempts = v
+	}
+
+	if v, err := time.ParseDuration(baseDelay()); err != nil || v <= 0 {
+		log.Info("cannot convert retry option 'RetryBaseDelay': ", zap.String("value", baseDelay()))
+	} else {
+		policy.BaseDelay = v
+	}
+
+	if v, err := time.ParseDuration(maxDelay()); err != nil || v < policy.BaseDelay {
+		log.Info("cannot convert retry option 'RetryMaxDelay': ", zap.String("value", maxDelay()))
+	} else {
+		policy.MaxDelay = v
+	}
+
+	return policy
+}
+
+// Exhausted reports whether a message that was attempted the given number of times must not be retried
+func (p RetryPolicy) Exhausted(attempts int) bool {
+	return attempts >= p.MaxAttempts
+}
+
+// Backoff returns the delay before the next attempt after the given number of attempts.
+// The delay doubles with every attempt up to MaxDelay, and a random jitter of up
+// to half of it is subtracted so that relays do not retry in lockstep.
+func (p RetryPolicy) Backoff(attempts int) time.Duration {
+	delay := p.MaxDelay
+	if attempts < 1 {
+		attempts = 1
+	}
+	if shift := attempts - 1; shift < 32 {
+		if d := p.BaseDelay << shift; d > 0 && d < p.MaxDelay {
+			delay = d
+		}
+	}
+
+	half := int64(delay / 2)
+	if half <= 0 {
+		return delay
+	}
+
+	return delay - time.Duration(rand.Int63n(half))
+}
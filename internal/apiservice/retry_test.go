@@ -0,0 +1,67 @@
+package apiservice
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyExhausted(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3}
+
+	tests := []struct {
+		attempts int
+		want     bool
+	}{
+		{attempts: 0, want: false},
+		{attempts: 1, want: false},
+		{attempts: 2, want: false},
+		{attempts: 3, want: true},
+		{attempts: 4, want: true},
+	}
+
+	for _, tt := range tests {
+		if got := policy.Exhausted(tt.attempts); got != tt.want {
+			t.Errorf("Exhausted(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: time.Minute}
+
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		attempts int
+		// the delay before jitter, Backoff returns a value in (delay/2, delay]
+		delay time.Duration
+	}{
+		{name: "no attempts", policy: policy, attempts: 0, delay: time.Second},
+		{name: "first attempt", policy: policy, attempts: 1, delay: time.Second},
+		{name: "second attempt", policy: policy, attempts: 2, delay: 2 * time.Second},
+		{name: "third attempt", policy: policy, attempts: 3, delay: 4 * time.Second},
+		{name: "last before cap", policy: policy, attempts: 6, delay: 32 * time.Second},
+		{name: "capped", policy: policy, attempts: 7, delay: time.Minute},
+		{name: "shift overflow", policy: policy, attempts: 40, delay: time.Minute},
+		{name: "duration overflow", policy: RetryPolicy{BaseDelay: time.Hour, MaxDelay: 1<<63 - 1}, attempts: 30, delay: 1<<63 - 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				got := tt.policy.Backoff(tt.attempts)
+				if got <= tt.delay/2 || got > tt.delay {
+					t.Fatalf("Backoff(%d) = %v, want in (%v, %v]", tt.attempts, got, tt.delay/2, tt.delay)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBackoffNoJitter(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Nanosecond, MaxDelay: time.Nanosecond}
+
+	if got := policy.Backoff(1); got != time.Nanosecond {
+		t.Errorf("Backoff(1) = %v, want %v", got, time.Nanosecond)
+	}
+}
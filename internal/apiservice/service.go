@@ -2,19 +2,52 @@ package apiservice
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/wurt83ow/gophstream/internal/kafka"
 	"github.com/wurt83ow/gophstream/internal/models"
+	"github.com/wurt83ow/gophstream/internal/tracing"
 	"github.com/wurt83ow/gophstream/internal/workerpool"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
+const (
+	// outboxBatchSize is the maximum number of outbox records claimed per tick
+	outboxBatchSize = 100
+	// outboxLease is how long a claimed record stays with this relay
+	// before another relay is allowed to claim it again
+	outboxLease = 30 * time.Second
+	// reconcileQueue is the number of claimed batches of uncertain records that
+	// can wait for reconciliation, further ones stay claimed until their lease expires
+	reconcileQueue = 16
+	// reconcileClockSkew widens the time range of the topic read back when
+	// reconciling, since Kafka timestamps come from another clock than Postgres
+	reconcileClockSkew = time.Minute
+	// releaseReason is recorded for the records whose delivery was still
+	// unknown when the relay shut down
+	releaseReason = "relay shut down before the delivery was confirmed"
+)
+
 type External interface {
-	SendMessageToKafka(message models.Message) (int, error)
+	OutboxMessage(record models.OutboxRecord) (models.OutgoingMessage, error)
+	PublishDeadLetter(ctx context.Context, record models.OutboxRecord, failure models.DeliveryFailure) error
+}
+
+// Publisher interface for sending messages to Kafka without waiting for the
+// broker; done receives the partition and offset of the message or the error.
+// Available is the number of messages it can take without waiting.
+type Publisher interface {
+	SendMessageAsync(ctx context.Context, message models.OutgoingMessage, done kafka.DeliveryFunc)
+	Available() int
 }
 
 type Log interface {
@@ -22,13 +55,23 @@ type Log interface {
 }
 
 type Storage interface {
-	GetMessages(context.Context, models.Filter, models.Pagination) ([]models.Message, error)
-	UpdateMessagesProcessed(context.Context, []int) error
+	ClaimOutbox(ctx context.Context, owner string, limit int, lease time.Duration) ([]models.OutboxRecord, error)
+	ClaimAbandonedOutbox(ctx context.Context, owner string, limit int, lease time.Duration) ([]models.OutboxRecord, error)
+	MarkOutboxDelivered(ctx context.Context, owner string, deliveries []models.Delivery) error
+	FailOutbox(ctx context.Context, owner string, failures []models.DeliveryFailure) error
+	ReleaseOutbox(ctx context.Context, owner, reason string) (int, error)
 }
 
+// Reconciler interface for finding messages that reached Kafka although their delivery was not recorded
+type Reconciler interface {
+	FindDeliveries(ctx context.Context, topic string, since time.Time, messageIDs map[int]bool) (map[int]models.Delivery, error)
+}
+
+// Pool interface for handling failed outbox records.
+// Available is the number of tasks it can queue without waiting.
 type Pool interface {
-	// NewTask(f func(interface{}) error, data interface{}) *workerpool.Task
-	AddTask(task *workerpool.Task)
+	TryAddTask(task *workerpool.Task) error
+	Available() int
 }
 
 type ApiService struct {
@@ -36,15 +79,37 @@ type ApiService struct {
 	results      chan interface{}
 	wg           sync.WaitGroup
 	cancelFunc   context.CancelFunc
+	stopPolling  context.CancelFunc
+	stopping     atomic.Bool
+	finish       chan context.Context
 	external     External
+	publisher    Publisher
 	pool         Pool
 	storage      Storage
 	log          Log
 	taskInterval int
+	owner        string
+	// stableOwner is set when owner is the configured relay ID, which is
+	// kept across restarts, so its earlier claims can be taken over
+	stableOwner bool
+	retry       RetryPolicy
+	reconciler  Reconciler
+	lastTick    atomic.Int64
+	// uncertain receives the claimed records that may already be in Kafka,
+	// they are reconciled in the background so that a topic scan does not
+	// hold up the relay loop
+	uncertain   chan []models.OutboxRecord
+	reconciling sync.WaitGroup
+}
+
+// outboxResult is the outcome of publishing a single outbox record
+type outboxResult struct {
+	delivery *models.Delivery
+	failure  *models.DeliveryFailure
 }
 
-func NewApiService(ctx context.Context, external External, pool Pool, storage Storage,
-	log Log, taskInterval func() string,
+func NewApiService(ctx context.Context, external External, publisher Publisher, pool Pool, storage Storage,
+	log Log, taskInterval func() string, relayID func() string, retry RetryPolicy, reconciler Reconciler,
 ) *ApiService {
 	taskInt, err := strconv.Atoi(taskInterval())
 	if err != nil {
@@ -53,80 +118,365 @@ func NewApiService(ctx context.Context, external External, pool Pool, storage St
 		taskInt = 3000
 	}
 
+	owner := relayID()
+	stableOwner := owner != ""
+	if !stableOwner {
+		owner = relayOwner()
+	}
+
 	return &ApiService{
 		ctx:          ctx,
 		results:      make(chan interface{}),
 		wg:           sync.WaitGroup{},
 		cancelFunc:   nil,
+		finish:       make(chan context.Context),
 		external:     external,
+		publisher:    publisher,
 		pool:         pool,
 		storage:      storage,
 		log:          log,
 		taskInterval: taskInt,
+		owner:        owner,
+		stableOwner:  stableOwner,
+		retry:        retry,
+		reconciler:   reconciler,
+		uncertain:    make(chan []models.OutboxRecord, reconcileQueue),
+	}
+}
+
+// relayOwner returns an identifier of this relay process used to claim outbox
+// records when no relay ID is configured. It changes on every restart.
+func relayOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
 	}
+
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
 }
 
 func (a *ApiService) Start() {
+	a.lastTick.Store(time.Now().UnixNano())
 	a.ctx, a.cancelFunc = context.WithCancel(a.ctx)
+
+	var pollCtx context.Context
+	pollCtx, a.stopPolling = context.WithCancel(a.ctx)
 	a.wg.Add(1)
-	go a.ProcessMessages(a.ctx)
+	go a.ProcessMessages(pollCtx)
+
+	if a.reconciler != nil {
+		a.reconciling.Add(1)
+		go a.reconcileUncertain(pollCtx)
+	}
 }
 
+// Stop stops claiming outbox records. The results of the records already
+// handed to the publisher keep being collected until Finish is called.
 func (a *ApiService) Stop() {
-	a.cancelFunc()
+	a.stopping.Store(true)
+	if a.stopPolling != nil {
+		a.stopPolling()
+	}
+}
+
+// Finish records the results collected since the last tick and releases the
+// records whose delivery is still unknown, so that they are sent again.
+// It is called after Stop, once the publisher has been flushed.
+func (a *ApiService) Finish(ctx context.Context) error {
+	if a.cancelFunc == nil {
+		return nil
+	}
+
+	a.Stop()
+	// the reconciler hands its results to the relay loop, which runs until finish
+	a.reconciling.Wait()
+	select {
+	case a.finish <- ctx:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 	a.wg.Wait()
+	a.cancelFunc()
+
+	released, err := a.storage.ReleaseOutbox(ctx, a.owner, releaseReason)
+	if err != nil {
+		return fmt.Errorf("cannot release outbox records: %w", err)
+	}
+	if released != 0 {
+		a.log.Info("released unfinished outbox records", zap.Int("records", released))
+	}
+
+	return nil
+}
+
+// LastTick returns when the relay last claimed outbox records successfully
+func (a *ApiService) LastTick() time.Time {
+	return time.Unix(0, a.lastTick.Load())
+}
+
+// TickInterval returns the interval between two ticks of the relay
+func (a *ApiService) TickInterval() time.Duration {
+	return time.Duration(a.taskInterval) * time.Millisecond
 }
 
 func (a *ApiService) ProcessMessages(ctx context.Context) {
-	t := time.NewTicker(time.Duration(a.taskInterval) * time.Millisecond)
+	defer a.wg.Done()
 
-	result := make([]int, 0)
+	delivered := make([]models.Delivery, 0)
+	failed := make([]models.DeliveryFailure, 0)
 
-	var dmx sync.RWMutex
+	t := time.NewTicker(time.Duration(a.taskInterval) * time.Millisecond)
+	defer t.Stop()
 
-	dmx.RLock()
-	defer dmx.RUnlock()
+	polling := ctx.Done()
+	tick := t.C
 
 	for {
 		select {
-		case <-ctx.Done():
+		case <-polling:
+			// keep collecting the results of the published records until Finish
+			t.Stop()
+			polling, tick = nil, nil
+		case finishCtx := <-a.finish:
+			a.acknowledge(finishCtx, delivered, failed)
 			return
 		case job := <-a.results:
-			j, ok := job.(int)
-			if ok {
-				result = append(result, j)
+			res, ok := job.(outboxResult)
+			if !ok {
+				continue
 			}
-		case <-t.C:
-
-			processed := false
-			filter := models.Filter{
-				Processed: &processed,
+			if res.failure != nil {
+				failed = append(failed, *res.failure)
+			} else if res.delivery != nil {
+				delivered = append(delivered, *res.delivery)
 			}
+		case <-tick:
+			delivered, failed = nil, a.poll(ctx, delivered, failed)
+		}
+	}
+}
 
-			pagination := models.Pagination{
-				Limit: 100,
-			}
+// poll acknowledges the results of the previous tick, then claims
+// and publishes the next outbox records, all within one span.
+// Records with an earlier attempt are handed to the background reconciler.
+// It returns the failures of the records that were not handed to the
+// publisher or the worker pool, to be acknowledged on the next tick.
+func (a *ApiService) poll(ctx context.Context, delivered []models.Delivery, failed []models.DeliveryFailure) []models.DeliveryFailure {
+	start := time.Now()
+	defer func() { pollDuration.Observe(time.Since(start).Seconds()) }()
+
+	ctx, span := tracing.Tracer().Start(ctx, "outbox poll", trace.WithAttributes(
+		attribute.Int("outbox.delivered", len(delivered)),
+		attribute.Int("outbox.failed", len(failed)),
+	))
+	defer span.End()
+
+	// acknowledge the results of the previous tick before claiming new records
+	a.acknowledge(ctx, delivered, failed)
+
+	limit := a.claimLimit()
+	if limit == 0 {
+		span.SetAttributes(attribute.Bool("outbox.no_capacity", true))
+		a.log.Info("publisher or worker pool is full, outbox records are not claimed this tick")
+		a.lastTick.Store(time.Now().UnixNano())
+		return nil
+	}
 
-			messages, err := a.storage.GetMessages(ctx, filter, pagination)
+	records, err := a.storage.ClaimOutbox(ctx, a.owner, limit, outboxLease)
+	if err != nil {
+		tracing.RecordError(span, err)
+		a.log.Info("cannot claim outbox records: ", zap.Error(err))
+		return nil
+	}
+	pollBatchSize.Observe(float64(len(records)))
+	span.SetAttributes(attribute.Int("outbox.claimed", len(records)))
+	a.lastTick.Store(time.Now().UnixNano())
 
-			if err != nil {
+	if a.reconciler != nil {
+		records = a.deferUncertain(records)
+	}
+
+	return a.publish(records)
+}
+
+// claimLimit returns how many outbox records can be claimed now. No more
+// records are claimed than the publisher can take, so that a slow broker
+// does not leave them claimed while they wait to be sent, nor than the
+// worker pool can take, since every record that fails is handled there.
+func (a *ApiService) claimLimit() int {
+	return min(outboxBatchSize, a.publisher.Available(), a.pool.Available())
+}
+
+// deferUncertain queues the records whose earlier attempt may have reached
+// Kafka for reconciliation and returns the others. If the queue is full the
+// uncertain records stay claimed and are claimed again once their lease expires.
+func (a *ApiService) deferUncertain(records []models.OutboxRecord) []models.OutboxRecord {
+	var certain, uncertain []models.OutboxRecord
+	for _, rec := range records {
+		if rec.Attempts > 1 {
+			uncertain = append(uncertain, rec)
+		} else {
+			certain = append(certain, rec)
+		}
+	}
+	if len(uncertain) == 0 {
+		return certain
+	}
+
+	select {
+	case a.uncertain <- uncertain:
+	default:
+		a.log.Info("reconciliation queue is full, uncertain outbox records wait for their lease to expire",
+			zap.Int("records", len(uncertain)))
+	}
+
+	return certain
+}
+
+// reconcileUncertain takes over the claims left by the previous process of
+// this relay, then reconciles the uncertain records claimed by poll until ctx
+// is done. It runs beside the relay loop and hands the results to it.
+func (a *ApiService) reconcileUncertain(ctx context.Context) {
+	defer a.reconciling.Done()
+
+	// without a stable relay ID the claims of the previous process are
+	// taken over by ClaimOutbox once their lease expires, and reconciled then
+	if a.stableOwner {
+		a.recover(ctx)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case records := <-a.uncertain:
+			a.addFailures(a.publish(a.reconcile(ctx, records)))
+		}
+	}
+}
 
+// addFailures hands the failures of records that were not published to the relay loop
+func (a *ApiService) addFailures(failed []models.DeliveryFailure) {
+	for i := range failed {
+		a.AddResults(outboxResult{failure: &failed[i]})
+	}
+}
+
+// acknowledge records the collected results of published outbox records
+func (a *ApiService) acknowledge(ctx context.Context, delivered []models.Delivery, failed []models.DeliveryFailure) {
+	if len(delivered) != 0 {
+		outboxResults.WithLabelValues("delivered").Add(float64(len(delivered)))
+		a.doWork(ctx, delivered)
+	}
+	if len(failed) != 0 {
+		outboxResults.WithLabelValues("failed").Add(float64(len(failed)))
+		a.fail(ctx, failed)
+	}
+}
+
+// recover takes over the records left claimed under the relay ID by the previous
+// process of this relay, which may have been written to Kafka without their
+// delivery being recorded, and publishes the ones that are not found in their topic.
+// The relay ID must not be shared by relays running at the same time.
+// Like poll it claims no more records than the publisher can take, and waits
+// a tick while the publisher is full.
+func (a *ApiService) recover(ctx context.Context) {
+	for {
+		limit := a.claimLimit()
+		if limit == 0 {
+			select {
+			case <-ctx.Done():
 				return
+			case <-time.After(a.TickInterval()):
+				continue
+			}
+		}
+
+		records, err := a.storage.ClaimAbandonedOutbox(ctx, a.owner, limit, outboxLease)
+		if err != nil {
+			a.log.Info("cannot claim abandoned outbox records: ", zap.Error(err))
+			return
+		}
+		if len(records) == 0 {
+			return
+		}
+
+		a.log.Info("recovering abandoned outbox records", zap.Int("records", len(records)))
+		a.addFailures(a.publish(a.reconcile(ctx, records)))
+
+		if len(records) < limit {
+			return
+		}
+	}
+}
+
+// reconcile looks up the uncertain records in their topic, marks the ones
+// found there as delivered and returns the records that still have to be
+// published. Records whose topic cannot be read stay claimed until their
+// lease expires. Records whose range of the topic is too long to read back
+// are published without being checked.
+func (a *ApiService) reconcile(ctx context.Context, records []models.OutboxRecord) []models.OutboxRecord {
+	var rest []models.OutboxRecord
+	byTopic := make(map[string][]models.OutboxRecord)
+
+	for _, rec := range records {
+		message, err := a.external.OutboxMessage(rec)
+		if err != nil {
+			// a record that cannot be built was never written
+			rest = append(rest, rec)
+			continue
+		}
+		byTopic[message.Topic] = append(byTopic[message.Topic], rec)
+	}
+
+	var delivered []models.Delivery
+	for topic, recs := range byTopic {
+		since := recs[0].CreatedAt
+		ids := make(map[int]bool, len(recs))
+		for _, rec := range recs {
+			if rec.CreatedAt.Before(since) {
+				since = rec.CreatedAt
 			}
+			ids[rec.MessageID] = true
+		}
 
-			a.CreateTask(messages)
+		found, err := a.reconciler.FindDeliveries(ctx, topic, since.Add(-reconcileClockSkew), ids)
+		if errors.Is(err, kafka.ErrScanRange) {
+			a.log.Info("outbox records published without reconciliation: ", zap.Error(err),
+				zap.String("topic", topic), zap.Int("records", len(recs)))
+			rest = append(rest, recs...)
+			continue
+		}
+		if err != nil {
+			a.log.Info("cannot reconcile outbox records: ", zap.Error(err), zap.String("topic", topic))
+			continue
+		}
 
-			if len(result) != 0 {
-				a.doWork(result)
-				result = nil
+		for _, rec := range recs {
+			d, ok := found[rec.MessageID]
+			if !ok {
+				rest = append(rest, rec)
+				continue
 			}
+			d.OutboxID = rec.ID
+			delivered = append(delivered, d)
 		}
 	}
+
+	if len(delivered) != 0 {
+		a.log.Info("outbox records found in Kafka", zap.Int("records", len(delivered)))
+		a.doWork(ctx, delivered)
+	}
+
+	return rest
 }
 
 // AddResults adds result to pool.
 func (a *ApiService) AddResults(result interface{}) {
-	a.results <- result
+	select {
+	case a.results <- result:
+	case <-a.ctx.Done():
+	}
 }
 
 func (a *ApiService) GetResults() <-chan interface{} {
@@ -134,35 +484,144 @@ func (a *ApiService) GetResults() <-chan interface{} {
 	return a.results
 }
 
-func (a *ApiService) CreateTask(messages []models.Message) {
-	var task *workerpool.Task
+// publish hands the claimed outbox records to the batch publisher.
+// The result of every record is collected and applied in bulk on the next tick.
+// Every record is published within the trace of the request that created it.
+// It runs on the relay loop, which is the only reader of the results, or on
+// the reconciler, so the failures of records that cannot be handed over are
+// returned for the caller to pass on.
+func (a *ApiService) publish(records []models.OutboxRecord) []models.DeliveryFailure {
+	var failed []models.DeliveryFailure
+	for _, record := range records {
+		rec := record
+
+		message, err := a.external.OutboxMessage(rec)
+		if err != nil {
+			if addErr := a.CreateTask(a.ctx, rec, err); addErr != nil {
+				failed = append(failed, a.retryFailure(rec, err))
+			}
+			continue
+		}
 
-	for _, message := range messages {
+		// the record is delivered only when Kafka reports the offset it was written at
+		ctx := tracing.ContextWithTraceParent(a.ctx, message.Headers[tracing.TraceParentHeader])
+		a.publisher.SendMessageAsync(ctx, message, func(delivery models.Delivery, err error) {
+			if err != nil {
+				if addErr := a.CreateTask(ctx, rec, err); addErr != nil {
+					failure := a.retryFailure(rec, err)
+					a.AddResults(outboxResult{failure: &failure})
+				}
+				return
+			}
 
-		task = workerpool.NewTask(func(data interface{}) error {
+			delivery.OutboxID = rec.ID
+			a.log.Info("published outbox record: ", zap.Int64("outboxID", rec.ID), zap.Int("messageID", rec.MessageID),
+				zap.Int("partition", delivery.Partition), zap.Int64("offset", delivery.Offset))
+			a.AddResults(outboxResult{delivery: &delivery})
+		})
+	}
 
-			msg, ok := data.(models.Message)
-			if ok { // type assertion failed
-				msg_id, err := a.external.SendMessageToKafka(msg)
+	return failed
+}
 
-				if err != nil {
-					return fmt.Errorf("failed to create order task: %w", err)
-				}
-				a.log.Info("processed task: ", zap.String("usefinfo", fmt.Sprintf("%d%d", msg.ID)))
-				a.AddResults(msg_id)
-			}
+// CreateTask handles a failed outbox record in the worker pool,
+// since publishing it to the dead-letter topic may take a while.
+// The task is traced as part of the trace in ctx. Once the relay is
+// stopping the record is left to Finish, which releases it for a retry.
+// The task is added without waiting for room in the queue; if it cannot be
+// queued the error is returned and the caller reschedules the record.
+func (a *ApiService) CreateTask(ctx context.Context, record models.OutboxRecord, err error) error {
+	if a.stopping.Load() {
+		a.log.Info("relay is stopping, outbox record left for release: ", zap.Int64("outboxID", record.ID), zap.Error(err))
+		return nil
+	}
+
+	task := workerpool.NewTask(ctx, func(ctx context.Context, data interface{}) (interface{}, error) {
+		rec, ok := data.(models.OutboxRecord)
+		if !ok { // type assertion failed
+			return nil, nil
+		}
+
+		return a.failure(ctx, rec, err), fmt.Errorf("failed to publish outbox record: %w", err)
+	}, record)
+	if addErr := a.pool.TryAddTask(task); addErr != nil {
+		a.log.Info("cannot queue failed outbox record: ", zap.Int64("outboxID", record.ID), zap.Error(addErr))
+		return addErr
+	}
+
+	go a.awaitTask(task, record, err)
+	return nil
+}
+
+// awaitTask collects the failure decided by the task of a failed outbox record.
+// If the task panicked, timed out or was dropped the record is retried with backoff.
+func (a *ApiService) awaitTask(task *workerpool.Task, record models.OutboxRecord, err error) {
+	res, waitErr := task.Wait(a.ctx)
+	if waitErr != nil {
+		return
+	}
 
-			return nil
-		}, message)
-		a.pool.AddTask(task)
+	failure, ok := res.Value.(models.DeliveryFailure)
+	if !ok {
+		a.log.Info("failed outbox record task did not finish: ", zap.Int64("outboxID", record.ID), zap.Error(res.Err))
+		failure = a.retryFailure(record, err)
 	}
+	a.AddResults(outboxResult{failure: &failure})
 }
 
-func (a *ApiService) doWork(ids []int) {
-	// perform a group update of the massages table (field Processed)
-	err := a.storage.UpdateMessagesProcessed(a.ctx, ids)
+// retryFailure reschedules a failed outbox record with backoff,
+// used when its failure could not be handled in the worker pool
+func (a *ApiService) retryFailure(record models.OutboxRecord, err error) models.DeliveryFailure {
+	class, _ := kafka.ClassifyError(err)
+	return models.DeliveryFailure{
+		OutboxID: record.ID,
+		Error:    err.Error(),
+		Class:    class,
+		RetryAt:  time.Now().Add(a.retry.Backoff(record.Attempts)),
+	}
+}
+
+// doWork marks the outbox records acknowledged by Kafka as delivered
+// and records the partition and offset of their messages
+func (a *ApiService) doWork(ctx context.Context, deliveries []models.Delivery) {
+	// perform a group update of the outbox and messages tables
+	err := a.storage.MarkOutboxDelivered(ctx, a.owner, deliveries)
 	if err != nil {
-		a.log.Info("errors when updating order status: ", zap.Error(err))
+		a.log.Info("errors when marking outbox records delivered: ", zap.Error(err))
+	}
+}
+
+// failure decides what happens to an outbox record that failed to publish.
+// Retriable errors are retried with backoff until the attempts are exhausted,
+// after that the record is published to the dead-letter topic.
+func (a *ApiService) failure(ctx context.Context, record models.OutboxRecord, err error) models.DeliveryFailure {
+	class, retriable := kafka.ClassifyError(err)
+	failure := models.DeliveryFailure{
+		OutboxID: record.ID,
+		Error:    err.Error(),
+		Class:    class,
 	}
 
+	if retriable && !a.retry.Exhausted(record.Attempts) {
+		failure.RetryAt = time.Now().Add(a.retry.Backoff(record.Attempts))
+		return failure
+	}
+
+	if err := a.external.PublishDeadLetter(ctx, record, failure); err != nil {
+		// keep the record until the dead-letter topic accepts it
+		a.log.Info("cannot publish dead letter: ", zap.Error(err), zap.Int64("outboxID", record.ID))
+		failure.RetryAt = time.Now().Add(a.retry.Backoff(record.Attempts))
+		return failure
+	}
+
+	failure.Dead = true
+	return failure
+}
+
+// fail reschedules or dead-letters the outbox records that failed to publish
+func (a *ApiService) fail(ctx context.Context, failures []models.DeliveryFailure) {
+	err := a.storage.FailOutbox(ctx, a.owner, failures)
+	if err != nil {
+		a.log.Info("errors when recording outbox failures: ", zap.Error(err))
+	}
 }
@@ -0,0 +1,246 @@
+package apiservice
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wurt83ow/gophstream/internal/kafka"
+	"github.com/wurt83ow/gophstream/internal/models"
+	"github.com/wurt83ow/gophstream/internal/workerpool"
+	"go.uber.org/zap/zapcore"
+)
+
+type nopLog struct{}
+
+func (nopLog) Info(string, ...zapcore.Field) {}
+
+// brokenExternal fails to build every outbox message
+type brokenExternal struct{}
+
+func (brokenExternal) OutboxMessage(models.OutboxRecord) (models.OutgoingMessage, error) {
+	return models.OutgoingMessage{}, errors.New("cannot build message")
+}
+
+func (brokenExternal) PublishDeadLetter(context.Context, models.OutboxRecord, models.DeliveryFailure) error {
+	return nil
+}
+
+// topicExternal builds every outbox message for the same topic
+type topicExternal struct{}
+
+func (topicExternal) OutboxMessage(models.OutboxRecord) (models.OutgoingMessage, error) {
+	return models.OutgoingMessage{Topic: "orders"}, nil
+}
+
+func (topicExternal) PublishDeadLetter(context.Context, models.OutboxRecord, models.DeliveryFailure) error {
+	return nil
+}
+
+type nopPublisher struct{}
+
+func (nopPublisher) SendMessageAsync(context.Context, models.OutgoingMessage, kafka.DeliveryFunc) {}
+
+func (nopPublisher) Available() int { return outboxBatchSize }
+
+// smallPublisher takes a few messages at a time
+type smallPublisher struct{ nopPublisher }
+
+func (smallPublisher) Available() int { return 3 }
+
+// blockingReconciler scans until its context is done
+type blockingReconciler struct{}
+
+func (blockingReconciler) FindDeliveries(ctx context.Context, _ string, _ time.Time, _ map[int]bool) (map[int]models.Delivery, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// emptyReconciler finds none of the records
+type emptyReconciler struct{}
+
+func (emptyReconciler) FindDeliveries(context.Context, string, time.Time, map[int]bool) (map[int]models.Delivery, error) {
+	return nil, nil
+}
+
+// fullPool rejects every task as if its queue had filled up since Available was called
+type fullPool struct{}
+
+func (fullPool) TryAddTask(*workerpool.Task) error { return workerpool.ErrQueueFull }
+
+func (fullPool) Available() int { return outboxBatchSize }
+
+// busyPool has no room for tasks
+type busyPool struct{ fullPool }
+
+func (busyPool) Available() int { return 0 }
+
+// fakeStorage hands out its records on the first claim and reports the failures recorded
+type fakeStorage struct {
+	mx       sync.Mutex
+	records  []models.OutboxRecord
+	failures chan []models.DeliveryFailure
+	// abandoned are handed out by ClaimAbandonedOutbox, limits records its limits
+	abandoned []models.OutboxRecord
+	limits    []int
+}
+
+func (s *fakeStorage) ClaimOutbox(context.Context, string, int, time.Duration) ([]models.OutboxRecord, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	records := s.records
+	s.records = nil
+	return records, nil
+}
+
+func (s *fakeStorage) ClaimAbandonedOutbox(_ context.Context, _ string, limit int, _ time.Duration) ([]models.OutboxRecord, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.limits = append(s.limits, limit)
+	n := min(limit, len(s.abandoned))
+	records := s.abandoned[:n]
+	s.abandoned = s.abandoned[n:]
+	return records, nil
+}
+
+func (s *fakeStorage) claimLimits() []int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return append([]int(nil), s.limits...)
+}
+
+func (s *fakeStorage) MarkOutboxDelivered(context.Context, string, []models.Delivery) error {
+	return nil
+}
+
+func (s *fakeStorage) FailOutbox(_ context.Context, _ string, failures []models.DeliveryFailure) error {
+	s.failures <- failures
+	return nil
+}
+
+func (s *fakeStorage) ReleaseOutbox(context.Context, string, string) (int, error) {
+	return 0, nil
+}
+
+// TestRelayQueueFull checks that records whose failure cannot be queued in the
+// worker pool are rescheduled by the relay loop instead of blocking it
+func TestRelayQueueFull(t *testing.T) {
+	storage := &fakeStorage{
+		records:  []models.OutboxRecord{{ID: 1, MessageID: 10, Attempts: 1}, {ID: 2, MessageID: 20, Attempts: 1}},
+		failures: make(chan []models.DeliveryFailure, 1),
+	}
+	retry := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: time.Minute}
+	a := NewApiService(context.Background(), brokenExternal{}, nopPublisher{}, fullPool{}, storage,
+		nopLog{}, func() string { return "10" }, func() string { return "" }, retry, nil)
+
+	a.Start()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := a.Finish(ctx); err != nil {
+			t.Errorf("Finish() error = %v", err)
+		}
+	}()
+
+	select {
+	case failures := <-storage.failures:
+		if len(failures) != 2 {
+			t.Fatalf("got %d failures, want 2", len(failures))
+		}
+		for _, f := range failures {
+			if f.Dead || f.RetryAt.IsZero() {
+				t.Errorf("failure of outbox record %d = %+v, want a retry", f.OutboxID, f)
+			}
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("relay loop did not record the failures of the records the pool rejected")
+	}
+}
+
+// finish stops the relay and fails the test if it does not finish in time
+func finish(t *testing.T, a *ApiService) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := a.Finish(ctx); err != nil {
+		t.Errorf("Finish() error = %v", err)
+	}
+}
+
+// TestRecoverClaimLimit checks that abandoned records are claimed
+// no faster than the publisher can take them
+func TestRecoverClaimLimit(t *testing.T) {
+	storage := &fakeStorage{failures: make(chan []models.DeliveryFailure, 1)}
+	for i := 1; i <= 5; i++ {
+		storage.abandoned = append(storage.abandoned, models.OutboxRecord{ID: int64(i), MessageID: i, Attempts: 1})
+	}
+	retry := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: time.Minute}
+	a := NewApiService(context.Background(), topicExternal{}, smallPublisher{}, fullPool{}, storage,
+		nopLog{}, func() string { return "10" }, func() string { return "relay-1" }, retry, emptyReconciler{})
+
+	a.Start()
+	defer finish(t, a)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(storage.claimLimits()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("abandoned records claimed with limits %v, want two claims", storage.claimLimits())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if limits := storage.claimLimits(); len(limits) != 2 || limits[0] != 3 || limits[1] != 3 {
+		t.Errorf("abandoned records claimed with limits %v, want [3 3]", limits)
+	}
+}
+
+// TestReconcileInBackground checks that the relay keeps ticking
+// while uncertain records are being reconciled
+func TestReconcileInBackground(t *testing.T) {
+	storage := &fakeStorage{
+		records:  []models.OutboxRecord{{ID: 1, MessageID: 10, Attempts: 2}},
+		failures: make(chan []models.DeliveryFailure, 1),
+	}
+	retry := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: time.Minute}
+	a := NewApiService(context.Background(), topicExternal{}, nopPublisher{}, fullPool{}, storage,
+		nopLog{}, func() string { return "10" }, func() string { return "" }, retry, blockingReconciler{})
+
+	a.Start()
+	defer finish(t, a)
+
+	// the first tick claims the uncertain record, the following ones must still happen
+	time.Sleep(20 * time.Millisecond)
+	since := time.Now()
+	time.Sleep(50 * time.Millisecond)
+	if !a.LastTick().After(since) {
+		t.Errorf("LastTick() = %v, want a tick after %v", a.LastTick(), since)
+	}
+}
+
+// TestPollClaimLimit checks that outbox records are claimed
+// no faster than both the publisher and the worker pool can take them
+func TestPollClaimLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		publisher Publisher
+		pool      Pool
+		want      int
+	}{
+		{name: "room", publisher: nopPublisher{}, pool: fullPool{}, want: outboxBatchSize},
+		{name: "publisher", publisher: smallPublisher{}, pool: fullPool{}, want: 3},
+		{name: "pool", publisher: nopPublisher{}, pool: busyPool{}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewApiService(context.Background(), topicExternal{}, tt.publisher, tt.pool, &fakeStorage{},
+				nopLog{}, func() string { return "10" }, func() string { return "" }, RetryPolicy{}, nil)
+			if got := a.claimLimit(); got != tt.want {
+				t.Errorf("claimLimit() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -4,12 +4,16 @@ import (
 	"context"
 	"errors"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
 
 	"github.com/wurt83ow/gophstream/internal/apiservice"
 	"github.com/wurt83ow/gophstream/internal/kafka"
@@ -17,12 +21,23 @@ import (
 	"github.com/wurt83ow/gophstream/internal/bdkeeper"
 	"github.com/wurt83ow/gophstream/internal/config"
 	"github.com/wurt83ow/gophstream/internal/controllers"
+	"github.com/wurt83ow/gophstream/internal/events"
+	"github.com/wurt83ow/gophstream/internal/jobs"
 	"github.com/wurt83ow/gophstream/internal/logger"
 	"github.com/wurt83ow/gophstream/internal/middleware"
+	"github.com/wurt83ow/gophstream/internal/routing"
 	"github.com/wurt83ow/gophstream/internal/storage"
+	"github.com/wurt83ow/gophstream/internal/tracing"
 	"github.com/wurt83ow/gophstream/internal/workerpool"
 )
 
+const (
+	// receiptsGroupID is the consumer group that reads processed messages back from Kafka
+	receiptsGroupID = "gophstream-receipts"
+	// defaultShutdownTimeout is used when the configured timeout cannot be parsed
+	defaultShutdownTimeout = 30 * time.Second
+)
+
 type Server struct {
 	srv *http.Server
 	ctx context.Context
@@ -35,7 +50,12 @@ func NewServer(ctx context.Context) *Server {
 	return server
 }
 
-// Serve starts the server and handles signal interruption for graceful shutdown
+// Serve creates the components of the service, starts them in dependency order
+// and blocks until SIGINT or SIGTERM is received or the context of the server is
+// cancelled. The components are then stopped in reverse order: the HTTP server
+// stops accepting requests, the relay stops polling, the worker pool is drained,
+// the Kafka writers are flushed and closed, the records whose delivery is still
+// unknown are released for a retry and finally the database pool is closed.
 func (server *Server) Serve() {
 	// create and initialize a new option instance
 	option := config.NewOptions()
@@ -47,12 +67,26 @@ func (server *Server) Serve() {
 		log.Fatalln(err)
 	}
 
+	// check the kafka settings before any component is started
+	if err := option.ValidateKafka(); err != nil {
+		log.Fatalln(err)
+	}
+	kafkaConfig := newKafkaConfig(option)
+
+	// start exporting the spans of every component before any of them is created
+	if err := option.ValidateTracing(); err != nil {
+		log.Fatalln(err)
+	}
+	tracer, err := initializeTracing(server.ctx, option, nLogger)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
 	// initialize the keeper instance
 	keeper := initializeKeeper(option.DataBaseDSN, nLogger, option.UserUpdateInterval)
 	if keeper == nil {
 		nLogger.Debug("Failed to initialize keeper")
 	}
-	defer keeper.Close()
 
 	// initialize the storage instance
 	memoryStorage := initializeStorage(server.ctx, keeper, nLogger)
@@ -64,43 +98,175 @@ func (server *Server) Serve() {
 	var allTask []*workerpool.Task
 	pool := initializeWorkerPool(allTask, option, nLogger)
 
+	// create a new broker delivering the message events of all instances
+	broker := initializeBroker(server.ctx, memoryStorage, nLogger)
+
 	// create a new controller to process incoming requests
-	basecontr := initializeBaseController(server.ctx, memoryStorage, option.DefaultEndTime, nLogger)
+	basecontr := initializeBaseController(server.ctx, memoryStorage, broker, option.DefaultEndTime, option.IdempotencyTTL, option.MaxBatchSize, nLogger)
 
 	// get a middleware for logging requests
 	reqLog := middleware.NewReqLog(nLogger)
 
-	// start the worker pool in the background
-	go pool.RunBackground()
+	// create a new kafka producer
+	producer, err := initializeKafka(server.ctx, kafkaConfig, nLogger)
+	if err != nil {
+		log.Fatalln(err)
+	}
 
-	// create a new kafka
-	// Определение брокеров Kafka
-	brokers := []string{"broker1:9092", "broker2:9092"}
-	// Определение топика
-	topic := "example-topic"
-	kafka := initializeKafka(server.ctx, brokers, topic, nLogger)
+	// create a new router choosing the topic of every message
+	router, err := initializeRouter(option)
+	if err != nil {
+		log.Fatalln(err)
+	}
 
 	// create a new controller for creating outgoing requests
-	extcontr := initializeExtController(server.ctx, memoryStorage, kafka, nLogger)
+	extcontr := initializeExtController(server.ctx, memoryStorage, producer, router, option.DeadLetterTopic, nLogger)
+
+	// publish messages with the async writer of the producer, or in batches
+	var publisher apiservice.Publisher = producer
+	var batcher *kafka.Batcher
+	if !kafkaConfig.Async {
+		batcher = initializeBatcher(server.ctx, producer, kafkaConfig, nLogger)
+		publisher = batcher
+	}
+
+	// look up uncertain writes in their topics when dedupe is enabled
+	var reconciler apiservice.Reconciler
+	if kafkaConfig.Dedupe {
+		scanner, err := initializeScanner(kafkaConfig, nLogger)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		reconciler = scanner
+	}
+
+	apiService := initializeApiService(server.ctx, extcontr, publisher, reconciler, pool, memoryStorage, nLogger, option)
+
+	// create a new kafka consumer that records delivery receipts
+	consumer, err := initializeKafkaConsumer(server.ctx, kafkaConfig, router.Topics(), memoryStorage, nLogger)
+	if err != nil {
+		log.Fatalln(err)
+	}
 
-	apiService := initializeApiService(server.ctx, extcontr, pool, memoryStorage, nLogger, option)
-	apiService.Start()
+	// create a new controller for operator requests
+	admincontr := initializeAdminController(server.ctx, memoryStorage, pool, router, nLogger)
+
+	// create a new controller to report consumer statistics
+	consumercontr := initializeConsumerController(server.ctx, consumer, nLogger)
+
+	// create a new controller for the liveness and readiness probes
+	healthcontr := initializeHealthController(server.ctx, memoryStorage, producer, pool, apiService, nLogger)
 
 	// create router and mount routes
 	r := chi.NewRouter()
 	r.Use(reqLog.RequestLogger)
+	r.Use(middleware.Tracing)
+	r.Use(middleware.Metrics)
+	r.Handle("/metrics", promhttp.Handler())
+	r.Get("/healthz", healthcontr.Liveness)
+	r.Get("/readyz", healthcontr.Readiness)
 	r.Mount("/", basecontr.Route())
+	r.Mount("/api/admin", admincontr.Route())
+	r.Mount("/api/consumer", consumercontr.Route())
+
+	// register the components, each one after the components it depends on
+	lifecycle := NewLifecycle(nLogger)
+	if tracer != nil {
+		lifecycle.Add("tracing", nil, tracer.Shutdown)
+	}
+	if keeper != nil {
+		lifecycle.Add("database", nil, func(context.Context) error {
+			keeper.Close()
+			return nil
+		})
+	}
+	lifecycle.Add("events broker", startFunc(broker.Start), stopFunc(broker.Stop))
+	// runs once the publisher is flushed, so that every known result is recorded first
+	lifecycle.Add("outbox release", nil, apiService.Finish)
+	lifecycle.Add("kafka producer", nil, func(context.Context) error {
+		producer.Close()
+		return nil
+	})
+	if batcher != nil {
+		lifecycle.Add("kafka batcher", startFunc(batcher.Start), func(ctx context.Context) error {
+			batcher.Stop(ctx)
+			return nil
+		})
+	}
+	lifecycle.Add("kafka consumer", startFunc(consumer.Start), stopFunc(consumer.Stop))
+	lifecycle.Add("worker pool", startFunc(func() { go pool.RunBackground() }), func(ctx context.Context) error {
+		err := pool.Drain(ctx)
+		pool.Stop()
+		return err
+	})
+	lifecycle.Add("outbox relay", startFunc(apiService.Start), stopFunc(apiService.Stop))
+	lifecycle.Add("http server", func() error {
+		srv, err := startServer(r, option.RunAddr())
+		server.srv = srv
+		return err
+	}, func(ctx context.Context) error {
+		return server.srv.Shutdown(ctx)
+	})
+
+	shutdownTimeout := parseShutdownTimeout(option.ShutdownTimeout, nLogger)
+
+	if err := lifecycle.Start(server.ctx); err != nil {
+		log.Fatalln(err)
+	}
+
+	// block until a termination signal is received
+	ctx, stop := signal.NotifyContext(server.ctx, os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+	nLogger.Info("shutting down", zap.Duration("timeout", shutdownTimeout))
+
+	ctxShutdown, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	lifecycle.Stop(ctxShutdown)
+	nLogger.Info("server exited properly")
+}
+
+// startFunc adapts a Start method that cannot fail to a lifecycle start function
+func startFunc(start func()) func() error {
+	return func() error {
+		start()
+		return nil
+	}
+}
 
-	// configure and start the server
-	server.srv = startServer(r, option.RunAddr())
+// stopFunc adapts a Stop method without a deadline to a lifecycle stop function
+func stopFunc(stop func()) func(context.Context) error {
+	return func(context.Context) error {
+		stop()
+		return nil
+	}
+}
 
-	// Create a channel to receive interrupt signals (e.g., CTRL+C)
-	stopChan := make(chan os.Signal, 1)
-	signal.Notify(stopChan, os.Interrupt)
+// parseShutdownTimeout reads the shutdown timeout option
+func parseShutdownTimeout(shutdownTimeout func() string, logger *logger.Logger) time.Duration {
+	d, err := time.ParseDuration(shutdownTimeout())
+	if err != nil || d <= 0 {
+		logger.Info("cannot parse shutdown timeout option, using the default", zap.String("value", shutdownTimeout()))
+		return defaultShutdownTimeout
+	}
 
-	// Block execution until a signal is received
-	<-stopChan
+	return d
+}
 
+// initializeTracing installs the span provider for the configured exporter,
+// it returns nil when tracing is disabled
+func initializeTracing(ctx context.Context, option *config.Options, logger *logger.Logger) (*tracing.Provider, error) {
+	provider, err := tracing.NewProvider(ctx, tracing.Config{
+		Exporter:     option.TracingExporter(),
+		OTLPEndpoint: option.TracingOTLPEndpoint(),
+		File:         option.TracingFile(),
+		ServiceName:  option.TracingServiceName(),
+	})
+	if provider != nil {
+		logger.Info("Tracing enabled", zap.String("exporter", option.TracingExporter()))
+	}
+
+	return provider, err
 }
 
 // initializeKeeper initializes a BDKeeper instance
@@ -124,40 +290,126 @@ func initializeStorage(ctx context.Context, keeper storage.Keeper, logger *logge
 }
 
 // initializeBaseController initializes a BaseController instance
-func initializeBaseController(ctx context.Context, storage *storage.MemoryStorage, DefaultEndTime func() string,
-	logger *logger.Logger,
+func initializeBaseController(ctx context.Context, storage *storage.MemoryStorage, feed controllers.Feed,
+	DefaultEndTime func() string, IdempotencyTTL func() string, MaxBatchSize func() string, logger *logger.Logger,
 ) *controllers.BaseController {
-	return controllers.NewBaseController(ctx, storage, DefaultEndTime, logger)
+	return controllers.NewBaseController(ctx, storage, feed, DefaultEndTime, IdempotencyTTL, MaxBatchSize, logger)
+}
+
+// initializeBroker initializes a Broker instance
+func initializeBroker(ctx context.Context, storage *storage.MemoryStorage, logger *logger.Logger) *events.Broker {
+	return events.NewBroker(ctx, storage, logger)
 }
 
 // initializeWorkerPool initializes a worker pool with the provided tasks and options
 func initializeWorkerPool(allTask []*workerpool.Task, option *config.Options, logger *logger.Logger) *workerpool.Pool {
-	return workerpool.NewPool(allTask, option.Concurrency, logger, option.TaskExecutionInterval)
+	return workerpool.NewPool(allTask, option.Concurrency, logger, option.TaskExecutionInterval, option.TaskTimeout,
+		option.QueueCapacity, option.QueuePolicy)
 }
 
-// initializeBaseController initializes a BaseController instance
-func initializeKafka(ctx context.Context, brokers []string, topic string, logger *logger.Logger) *kafka.KafkaProducerImpl {
-	return kafka.NewKafkaProducer(ctx, brokers, topic, logger)
+// newKafkaConfig collects the kafka settings from the options
+func newKafkaConfig(option *config.Options) kafka.Config {
+	return kafka.Config{
+		Brokers:               option.KafkaBrokers(),
+		Topic:                 option.KafkaTopic(),
+		ClientID:              option.KafkaClientID(),
+		RequiredAcks:          option.KafkaRequiredAcks(),
+		Compression:           option.KafkaCompression(),
+		BatchSize:             option.KafkaBatchSize(),
+		BatchBytes:            option.KafkaBatchBytes(),
+		BatchTimeout:          option.KafkaBatchTimeout(),
+		Async:                 option.KafkaAsync(),
+		Dedupe:                option.KafkaDedupe(),
+		SASLMechanism:         option.KafkaSASLMechanism(),
+		SASLUsername:          option.KafkaSASLUsername(),
+		SASLPassword:          option.KafkaSASLPassword(),
+		TLSEnabled:            option.KafkaTLSEnabled(),
+		TLSCAFile:             option.KafkaTLSCAFile(),
+		TLSCertFile:           option.KafkaTLSCertFile(),
+		TLSKeyFile:            option.KafkaTLSKeyFile(),
+		TLSInsecureSkipVerify: option.KafkaTLSInsecureSkipVerify(),
+	}
+}
+
+// initializeKafka initializes a KafkaProducerImpl instance
+func initializeKafka(ctx context.Context, cfg kafka.Config, logger *logger.Logger) (*kafka.KafkaProducerImpl, error) {
+	return kafka.NewKafkaProducer(ctx, cfg, logger)
+}
+
+// initializeBatcher initializes a Batcher instance
+func initializeBatcher(ctx context.Context, producer *kafka.KafkaProducerImpl, cfg kafka.Config, logger *logger.Logger) *kafka.Batcher {
+	return kafka.NewBatcher(ctx, producer, cfg, logger)
+}
+
+// initializeScanner initializes a Scanner instance
+func initializeScanner(cfg kafka.Config, logger *logger.Logger) (*kafka.Scanner, error) {
+	return kafka.NewScanner(cfg, logger)
+}
+
+// initializeKafkaConsumer initializes a KafkaConsumer instance
+func initializeKafkaConsumer(ctx context.Context, cfg kafka.Config, topics []string, storage *storage.MemoryStorage, logger *logger.Logger) (*kafka.KafkaConsumer, error) {
+	return kafka.NewKafkaConsumer(ctx, cfg, receiptsGroupID, topics, storage, logger)
+}
+
+// initializeAdminController initializes an AdminController instance
+func initializeAdminController(ctx context.Context, storage *storage.MemoryStorage, pool *workerpool.Pool,
+	router controllers.Router, logger *logger.Logger,
+) *controllers.AdminController {
+	return controllers.NewAdminController(ctx, storage, pool, jobs.NewTracker(), router, logger)
+}
+
+// initializeConsumerController initializes a ConsumerController instance
+func initializeConsumerController(ctx context.Context, consumer controllers.Consumer, logger *logger.Logger) *controllers.ConsumerController {
+	return controllers.NewConsumerController(ctx, consumer, logger)
+}
+
+// initializeHealthController initializes a HealthController instance
+func initializeHealthController(ctx context.Context, storage *storage.MemoryStorage, kafka controllers.HealthKafka,
+	pool *workerpool.Pool, relay controllers.Relay, logger *logger.Logger,
+) *controllers.HealthController {
+	return controllers.NewHealthController(ctx, storage, kafka, pool, relay, logger)
 }
 
 // initializeExtController initializes an ExtController instance
-func initializeExtController(ctx context.Context, storage *storage.MemoryStorage, kafka controllers.KafkaProducer, logger *logger.Logger) *controllers.ExtController {
-	return controllers.NewExtController(ctx, storage, kafka, logger)
+func initializeExtController(ctx context.Context, storage *storage.MemoryStorage, kafka controllers.KafkaProducer,
+	router controllers.Router, deadLetterTopic func() string, logger *logger.Logger,
+) *controllers.ExtController {
+	return controllers.NewExtController(ctx, storage, kafka, router, deadLetterTopic, logger)
+}
+
+// initializeRouter loads the routing rules and initializes a Router instance
+func initializeRouter(option *config.Options) (*routing.Router, error) {
+	rules, err := routing.LoadRules(option.RoutingRulesFile())
+	if err != nil {
+		return nil, err
+	}
+
+	return routing.NewRouter(rules, option.KafkaTopic), nil
 }
 
 // initializeApiService initializes an ApiService instance
-func initializeApiService(ctx context.Context, extcontr *controllers.ExtController, pool *workerpool.Pool, memoryStorage *storage.MemoryStorage, logger *logger.Logger, option *config.Options) *apiservice.ApiService {
-	apiService := apiservice.NewApiService(ctx, extcontr, pool, memoryStorage, logger, option.TaskExecutionInterval)
+func initializeApiService(ctx context.Context, extcontr *controllers.ExtController, publisher apiservice.Publisher, reconciler apiservice.Reconciler, pool *workerpool.Pool, memoryStorage *storage.MemoryStorage, logger *logger.Logger, option *config.Options) *apiservice.ApiService {
+	retry := apiservice.NewRetryPolicy(option.RetryMaxAttempts, option.RetryBaseDelay, option.RetryMaxDelay, logger)
+	apiService := apiservice.NewApiService(ctx, extcontr, publisher, pool, memoryStorage, logger, option.TaskExecutionInterval, option.RelayID, retry, reconciler)
 	return apiService
 }
 
-// startServer configures and starts an HTTP server with the provided router and address
-func startServer(router chi.Router, address string) *http.Server {
+// startServer configures an HTTP server with the provided router and starts
+// serving on the address. Requests still running when the server shuts down,
+// such as live feeds, see their context cancelled.
+func startServer(router chi.Router, address string) (*http.Server, error) {
 	const (
 		oneMegabyte = 1 << 20
 		readTimeout = 3 * time.Second
 	)
 
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	baseCtx, cancel := context.WithCancel(context.Background())
+
 	server := &http.Server{
 		Addr:                         address,
 		Handler:                      router,
@@ -171,36 +423,17 @@ func startServer(router chi.Router, address string) *http.Server {
 		TLSNextProto:                 nil,
 		ConnState:                    nil,
 		ErrorLog:                     nil,
-		BaseContext:                  nil,
+		BaseContext:                  func(net.Listener) context.Context { return baseCtx },
 		ConnContext:                  nil,
 	}
+	server.RegisterOnShutdown(cancel)
 
 	go func() {
-		err := server.ListenAndServe()
+		err := server.Serve(listener)
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatalln(err)
 		}
 	}()
 
-	return server
-}
-
-// Shutdown gracefully shuts down the server
-func (server *Server) Shutdown(timeout time.Duration) {
-	ctxShutDown, cancel := context.WithTimeout(server.ctx, timeout)
-	defer cancel()
-
-	log.Println("attempting to stop the server")
-
-	if server.srv != nil {
-		if err := server.srv.Shutdown(ctxShutDown); err != nil {
-			if !errors.Is(err, http.ErrServerClosed) {
-				log.Printf("server Shutdown Failed: %s", err)
-				return
-			}
-		}
-		log.Println("server stopped")
-	}
-
-	log.Println("server exited properly")
+	return server, nil
 }
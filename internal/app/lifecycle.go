@@ -0,0 +1,76 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type Log interface {
+	Info(string, ...zapcore.Field)
+}
+
+// component is a part of the service managed by the Lifecycle.
+// Either function may be nil.
+type component struct {
+	name  string
+	start func() error
+	stop  func(ctx context.Context) error
+}
+
+// Lifecycle starts the components of the service in the order they were added,
+// so every component is added after the components it depends on, and stops
+// them in the reverse order
+type Lifecycle struct {
+	components []component
+	started    int
+	log        Log
+}
+
+func NewLifecycle(log Log) *Lifecycle {
+	return &Lifecycle{log: log}
+}
+
+// Add registers a component started after the ones added before it
+func (l *Lifecycle) Add(name string, start func() error, stop func(ctx context.Context) error) {
+	l.components = append(l.components, component{name: name, start: start, stop: stop})
+}
+
+// Start starts the components in order. If one fails to start, the components
+// already started are stopped with ctx and its error is returned.
+func (l *Lifecycle) Start(ctx context.Context) error {
+	for _, c := range l.components {
+		if c.start != nil {
+			if err := c.start(); err != nil {
+				l.Stop(ctx)
+				return fmt.Errorf("cannot start %s: %w", c.name, err)
+			}
+		}
+		l.started++
+		l.log.Info("component started", zap.String("component", c.name))
+	}
+
+	return nil
+}
+
+// Stop stops the started components in reverse order. Each one gets what
+// remains of the deadline of ctx; a component failing to stop in time is
+// logged and the next one is stopped regardless.
+func (l *Lifecycle) Stop(ctx context.Context) {
+	for ; l.started > 0; l.started-- {
+		c := l.components[l.started-1]
+		if c.stop == nil {
+			continue
+		}
+
+		start := time.Now()
+		if err := c.stop(ctx); err != nil {
+			l.log.Info("component did not stop cleanly", zap.String("component", c.name), zap.Error(err))
+			continue
+		}
+		l.log.Info("component stopped", zap.String("component", c.name), zap.Duration("took", time.Since(start)))
+	}
+}
@@ -2,6 +2,8 @@ package bdkeeper
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"time"
@@ -12,7 +14,6 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/wurt83ow/gophstream/internal/models"
-	"github.com/wurt83ow/gophstream/internal/storage"
 	"go.uber.org/zap"
 )
 
@@ -20,9 +21,14 @@ type Log interface {
 	Info(string, ...zap.Field)
 }
 
+// pingTimeout bounds the round trip of Ping
+const pingTimeout = time.Second
+
 type BDKeeper struct {
-	pool *pgxpool.Pool
-	log  Log
+	pool               *pgxpool.Pool
+	schemaVersion      uint
+	log                Log
+	userUpdateInterval func() string
 }
 
 func NewBDKeeper(dsn func() string, log Log, userUpdateInterval func() string) *BDKeeper {
@@ -37,6 +43,7 @@ func NewBDKeeper(dsn func() string, log Log, userUpdateInterval func() string) *
 		log.Info("Unable to parse database DSN: ", zap.Error(err))
 		return nil
 	}
+	config.ConnConfig.Tracer = queryTracer{}
 
 	pool, err := pgxpool.NewWithConfig(context.Background(), config)
 	if err != nil {
@@ -86,10 +93,18 @@ func NewBDKeeper(dsn func() string, log Log, userUpdateInterval func() string) *
 		return nil
 	}
 
+	// the version the migrations of this build brought the schema to
+	version, _, err := m.Version()
+	if err != nil {
+		log.Info("Error getting migration version: ", zap.Error(err))
+	}
+
 	log.Info("Connected!")
+	registerPoolMetrics(pool)
 
 	return &BDKeeper{
 		pool:               pool,
+		schemaVersion:      version,
 		log:                log,
 		userUpdateInterval: userUpdateInterval,
 	}
@@ -105,87 +120,259 @@ func (kp *BDKeeper) Close() bool {
 	return false
 }
 
-func (kp *BDKeeper) Ping(ctx context.Context) bool {
-	ctx, cancel := context.WithTimeout(ctx, 1*time.Millisecond)
+func (kp *BDKeeper) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
 	defer cancel()
 
-	if err := kp.pool.Ping(ctx); err != nil {
-		return false
+	return kp.pool.Ping(ctx)
+}
+
+// SchemaVersion returns the migration version of the database together
+// with the version the migrations of this build expect
+func (kp *BDKeeper) SchemaVersion(ctx context.Context) (models.SchemaVersion, error) {
+	res := models.SchemaVersion{Expected: kp.schemaVersion}
+
+	err := kp.pool.QueryRow(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&res.Version, &res.Dirty)
+	if err != nil {
+		return models.SchemaVersion{}, err
 	}
 
-	return true
+	return res, nil
 }
 
-// LoadMessages loads messages from the database
-func (kp *BDKeeper) LoadMessages(ctx context.Context) (storage.StorageMessage, error) {
-	query := `
-    SELECT
+// messageColumns is the list of columns selected for a models.Message
+const messageColumns = `
         id,
         content,
+        topic,
+        type,
+        partition_key,
+        headers,
         created_at,
-        processed
-    FROM
-        messages`
+        status,
+        attempts,
+        last_error,
+        error_class,
+        sent_at,
+        kafka_partition,
+        kafka_offset,
+        trace_parent`
+
+// scanMessage scans a row selected with messageColumns
+func scanMessage(row pgx.Row) (models.Message, error) {
+	var m models.Message
+	var topic, msgType, key, lastError, errorClass, traceParent *string
+
+	err := row.Scan(
+		&m.ID,
+		&m.Content,
+		&topic,
+		&msgType,
+		&key,
+		&m.Headers,
+		&m.CreatedAt,
+		&m.Status,
+		&m.Attempts,
+		&lastError,
+		&errorClass,
+		&m.SentAt,
+		&m.KafkaPartition,
+		&m.KafkaOffset,
+		&traceParent,
+	)
+	if topic != nil {
+		m.Topic = *topic
+	}
+	if msgType != nil {
+		m.Type = *msgType
+	}
+	if key != nil {
+		m.Key = *key
+	}
+	if lastError != nil {
+		m.LastError = *lastError
+	}
+	if errorClass != nil {
+		m.ErrorClass = models.ErrorClass(*errorClass)
+	}
+	if traceParent != nil {
+		m.TraceParent = *traceParent
+	}
+
+	return m, err
+}
 
-	rows, err := kp.pool.Query(ctx, query)
+// InsertMessage inserts a new message into the database together with its outbox record.
+// If idem is set, the key is stored with the message in the same transaction; when the
+// key is already in use nothing is inserted and the stored record is returned instead.
+func (kp *BDKeeper) InsertMessage(ctx context.Context, message models.Message,
+	idem *models.IdempotencyRecord,
+) (int, *models.IdempotencyRecord, error) {
+	tx, err := kp.pool.Begin(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load messages: %w", err)
+		kp.log.Info("Error starting transaction: ", zap.Error(err))
+		return 0, nil, err
 	}
+	defer tx.Rollback(ctx)
 
-	defer rows.Close()
+	if idem != nil {
+		stored, err := reserveIdempotencyKey(ctx, tx, *idem)
+		if err != nil {
+			kp.log.Info("Error reserving idempotency key: ", zap.Error(err))
+			return 0, nil, err
+		}
+		if stored != nil {
+			return 0, stored, nil
+		}
+	}
 
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("failed to load messages: %w", err)
+	var id int
+	query := `
+    INSERT INTO messages (content, topic, type, partition_key, headers, created_at, status, trace_parent)
+    VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, ''), $5, $6, $7, NULLIF($8, ''))
+    RETURNING id`
+	err = tx.QueryRow(ctx, query, message.Content, message.Topic, message.Type, message.Key,
+		headersValue(message.Headers), message.CreatedAt, message.Status, message.TraceParent).Scan(&id)
+	if err != nil {
+		kp.log.Info("Error inserting message to database: ", zap.Error(err))
+		return 0, nil, err
 	}
 
-	data := make(map[int]models.Message)
+	message.ID = id
+	if err := insertOutbox(ctx, tx, message); err != nil {
+		kp.log.Info("Error inserting outbox record to database: ", zap.Error(err))
+		return 0, nil, err
+	}
 
-	for rows.Next() {
-		var m models.Message
-
-		err := rows.Scan(
-			&m.ID,
-			&m.Content,
-			&m.CreatedAt,
-			&m.Processed,
-		)
+	if idem != nil {
+		// the response of a request with an idempotency key is the created message
+		response, err := json.Marshal(message)
 		if err != nil {
-			return nil, fmt.Errorf("failed to load messages: %w", err)
+			return 0, nil, fmt.Errorf("failed to marshal idempotent response: %w", err)
 		}
+		if err := completeIdempotencyKey(ctx, tx, idem.Key, id, response); err != nil {
+			kp.log.Info("Error completing idempotency key: ", zap.Error(err))
+			return 0, nil, err
+		}
+	}
 
-		data[m.ID] = m
+	if err := tx.Commit(ctx); err != nil {
+		kp.log.Info("Error committing transaction: ", zap.Error(err))
+		return 0, nil, err
 	}
 
-	return data, nil
+	return id, nil, nil
 }
 
-// InsertMessage inserts a new message into the database
-func (kp *BDKeeper) InsertMessage(ctx context.Context, message models.Message) (int, error) {
-	var id int
-	query := `INSERT INTO messages (content, created_at, processed) VALUES ($1, $2, $3) RETURNING id`
-	err := kp.pool.QueryRow(ctx, query, message.Content, message.CreatedAt, message.Processed).Scan(&id)
+// InsertMessages inserts a batch of messages and their outbox records in one transaction.
+// The IDs are taken from the sequence first, so both tables can be written with COPY.
+// It returns the IDs in the order of the messages.
+func (kp *BDKeeper) InsertMessages(ctx context.Context, messages []models.Message) ([]int, error) {
+	tx, err := kp.pool.Begin(ctx)
 	if err != nil {
-		kp.log.Info("Error inserting message to database: ", zap.Error(err))
-		return 0, err
+		kp.log.Info("Error starting transaction: ", zap.Error(err))
+		return nil, err
 	}
-	return id, nil
+	defer tx.Rollback(ctx)
+
+	query := `SELECT nextval(pg_get_serial_sequence('messages', 'id'))::int FROM generate_series(1, $1)`
+	rows, err := tx.Query(ctx, query, len(messages))
+	if err != nil {
+		kp.log.Info("Error reserving message IDs: ", zap.Error(err))
+		return nil, err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve message IDs: %w", err)
+	}
+
+	messageRows := make([][]any, len(messages))
+	outboxRows := make([][]any, len(messages))
+	for i, message := range messages {
+		message.ID = ids[i]
+
+		payload, err := json.Marshal(message)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal outbox payload: %w", err)
+		}
+
+		messageRows[i] = []any{message.ID, message.Content, nullString(message.Topic), nullString(message.Type),
+			nullString(message.Key), headersValue(message.Headers), message.CreatedAt, string(message.Status),
+			nullString(message.TraceParent)}
+		outboxRows[i] = []any{message.ID, payload, string(models.OutboxPending)}
+	}
+
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"messages"},
+		[]string{"id", "content", "topic", "type", "partition_key", "headers", "created_at", "status", "trace_parent"},
+		pgx.CopyFromRows(messageRows))
+	if err != nil {
+		kp.log.Info("Error copying messages to database: ", zap.Error(err))
+		return nil, err
+	}
+
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"outbox"},
+		[]string{"message_id", "payload", "state"},
+		pgx.CopyFromRows(outboxRows))
+	if err != nil {
+		kp.log.Info("Error copying outbox records to database: ", zap.Error(err))
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		kp.log.Info("Error committing transaction: ", zap.Error(err))
+		return nil, err
+	}
+
+	return ids, nil
 }
 
-// GetMessages retrieves processed messages from the database based on the provided filter and pagination
-func (kp *BDKeeper) GetMessages(ctx context.Context, filter models.Filter, pagination models.Pagination) ([]models.Message, error) {
+// GetMessage retrieves a message by ID. It returns false if the message does not exist.
+func (kp *BDKeeper) GetMessage(ctx context.Context, id int) (models.Message, bool, error) {
+	query := `SELECT` + messageColumns + ` FROM messages WHERE id = $1`
+
+	message, err := scanMessage(kp.pool.QueryRow(ctx, query, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.Message{}, false, nil
+	}
+	if err != nil {
+		kp.log.Info("Error getting message from database: ", zap.Error(err))
+		return models.Message{}, false, err
+	}
+
+	return message, true, nil
+}
+
+// GetMessages retrieves messages from the database based on the provided filter, sort and pagination
+func (kp *BDKeeper) GetMessages(ctx context.Context, filter models.Filter, sort models.Sort,
+	pagination models.Pagination,
+) ([]models.Message, error) {
 	var messages []models.Message
+
+	conds, args := messageConditions(filter, nil)
+
 	var query string
-	var rows pgx.Rows
-	var err error
+	if pagination.Keyset {
+		if c := pagination.After; c != nil {
+			op := ">"
+			if sort.Desc {
+				op = "<"
+			}
+			args = append(args, c.CreatedAt, c.ID)
+			conds = append(conds, fmt.Sprintf("(created_at, id) %s ($%d, $%d)", op, len(args)-1, len(args)))
+		}
+		args = append(args, pagination.Limit)
 
-	if filter.Processed == nil {
-		query = `SELECT id, content, created_at, processed FROM messages LIMIT $1 OFFSET $2`
-		rows, err = kp.pool.Query(ctx, query, pagination.Limit, pagination.Offset)
+		sort.Field = models.SortByCreatedAt
+		query = `SELECT` + messageColumns + ` FROM messages` + whereClause(conds) + orderClause(sort) +
+			fmt.Sprintf(" LIMIT $%d", len(args))
 	} else {
-		query = `SELECT id, content, created_at, processed FROM messages WHERE processed = $1 LIMIT $2 OFFSET $3`
-		rows, err = kp.pool.Query(ctx, query, *filter.Processed, pagination.Limit, pagination.Offset)
+		args = append(args, pagination.Limit, pagination.Offset)
+
+		query = `SELECT` + messageColumns + ` FROM messages` + whereClause(conds) + orderClause(sort) +
+			fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)-1, len(args))
 	}
 
+	rows, err := kp.pool.Query(ctx, query, args...)
 	if err != nil {
 		kp.log.Info("Error getting messages from database: ", zap.Error(err))
 		return nil, err
@@ -193,8 +380,7 @@ func (kp *BDKeeper) GetMessages(ctx context.Context, filter models.Filter, pagin
 	defer rows.Close()
 
 	for rows.Next() {
-		var message models.Message
-		err := rows.Scan(&message.ID, &message.Content, &message.CreatedAt, &message.Processed)
+		message, err := scanMessage(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -207,13 +393,49 @@ func (kp *BDKeeper) GetMessages(ctx context.Context, filter models.Filter, pagin
 	return messages, nil
 }
 
-// UpdateMessagesProcessed updates the processed status of messages in the database
-func (kp *BDKeeper) UpdateMessagesProcessed(ctx context.Context, ids []int) error {
-	query := `UPDATE messages SET processed = true WHERE id = ANY($1)`
-	_, err := kp.pool.Exec(ctx, query, ids)
+// StreamMessages reads the messages matching the filter in the given order and
+// passes them to fn one at a time as they arrive from the database, so the
+// whole result is never held in memory. An error returned by fn stops the stream.
+func (kp *BDKeeper) StreamMessages(ctx context.Context, filter models.Filter, sort models.Sort,
+	fn func(models.Message) error,
+) error {
+	conds, args := messageConditions(filter, nil)
+	query := `SELECT` + messageColumns + ` FROM messages` + whereClause(conds) + orderClause(sort)
+
+	rows, err := kp.pool.Query(ctx, query, args...)
 	if err != nil {
-		kp.log.Info("Error updating messages processed status in database: ", zap.Error(err))
+		kp.log.Info("Error streaming messages from database: ", zap.Error(err))
 		return err
 	}
-	return nil
+	defer rows.Close()
+
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(message); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// headersValue returns the headers to store in the JSONB column, nil stores NULL
+func headersValue(headers map[string]string) any {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	return headers
+}
+
+// nullString returns nil for an empty string, so that it is stored as NULL
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+
+	return s
 }
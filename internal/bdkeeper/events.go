@@ -0,0 +1,89 @@
+package bdkeeper
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wurt83ow/gophstream/internal/models"
+	"go.uber.org/zap"
+)
+
+// messageEventsChannel is the NOTIFY channel the message_events trigger publishes to
+const messageEventsChannel = "message_events"
+
+// ListenMessageEvents passes the message events committed by any instance to fn
+// until the context is done or the connection fails. It holds a connection of its
+// own for the whole time, taken out of the pool so that it never returns to it
+// with LISTEN still active.
+func (kp *BDKeeper) ListenMessageEvents(ctx context.Context, fn func(models.MessageEvent)) error {
+	pooled, err := kp.pool.Acquire(ctx)
+	if err != nil {
+		kp.log.Info("Error acquiring connection for message events: ", zap.Error(err))
+		return err
+	}
+
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+messageEventsChannel); err != nil {
+		kp.log.Info("Error listening for message events: ", zap.Error(err))
+		return err
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		var event models.MessageEvent
+		if err := json.Unmarshal([]byte(notification.Payload), &event); err != nil {
+			kp.log.Info("Error decoding message event: ", zap.Error(err), zap.String("payload", notification.Payload))
+			continue
+		}
+		fn(event)
+	}
+}
+
+// GetMessageEvents returns up to limit message events recorded after the event afterID, oldest first
+func (kp *BDKeeper) GetMessageEvents(ctx context.Context, afterID int64, limit int) ([]models.MessageEvent, error) {
+	query := `
+    SELECT id, message_id, status, COALESCE(error, ''), COALESCE(error_class, ''),
+        kafka_partition, kafka_offset, created_at
+    FROM message_events
+    WHERE id > $1
+    ORDER BY id
+    LIMIT $2`
+
+	rows, err := kp.pool.Query(ctx, query, afterID, limit)
+	if err != nil {
+		kp.log.Info("Error selecting message events from database: ", zap.Error(err))
+		return nil, err
+	}
+
+	events, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.MessageEvent, error) {
+		var e models.MessageEvent
+		err := row.Scan(&e.ID, &e.MessageID, &e.Status, &e.Error, &e.ErrorClass,
+			&e.KafkaPartition, &e.KafkaOffset, &e.CreatedAt)
+		return e, err
+	})
+	if err != nil {
+		kp.log.Info("Error scanning message events: ", zap.Error(err))
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// PurgeMessageEvents deletes the message events recorded before the given time
+func (kp *BDKeeper) PurgeMessageEvents(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := kp.pool.Exec(ctx, `DELETE FROM message_events WHERE created_at < $1`, before)
+	if err != nil {
+		kp.log.Info("Error purging message events from database: ", zap.Error(err))
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,97 @@
+package bdkeeper
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/wurt83ow/gophstream/internal/models"
+)
+
+// sortColumns maps the sort fields to the columns of the messages table
+var sortColumns = map[models.SortField]string{
+	models.SortByID:        "id",
+	models.SortByCreatedAt: "created_at",
+	models.SortBySentAt:    "sent_at",
+	models.SortByStatus:    "status",
+	models.SortByAttempts:  "attempts",
+}
+
+// likeEscaper escapes the wildcard characters of a LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// messageConditions translates the filter into the conditions of a WHERE clause.
+// The values are appended to args and referenced as parameters.
+func messageConditions(filter models.Filter, args []any) ([]string, []any) {
+	var conds []string
+	add := func(cond string, value any) {
+		args = append(args, value)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if len(filter.Statuses) != 0 {
+		add("status = ANY($%d)", statusStrings(filter.Statuses))
+	}
+	if filter.CreatedFrom != nil {
+		add("created_at >= $%d", *filter.CreatedFrom)
+	}
+	if filter.CreatedTo != nil {
+		add("created_at < $%d", *filter.CreatedTo)
+	}
+	if filter.MinID != nil {
+		add("id >= $%d", *filter.MinID)
+	}
+	if filter.MaxID != nil {
+		add("id <= $%d", *filter.MaxID)
+	}
+	if filter.Contains != "" {
+		// served by the trigram index on content
+		add("content ILIKE '%%' || $%d || '%%'", likeEscaper.Replace(filter.Contains))
+	}
+	if filter.Search != "" {
+		add("to_tsvector('simple', content) @@ websearch_to_tsquery('simple', $%d)", filter.Search)
+	}
+	if filter.Topic != "" {
+		add("topic = $%d", filter.Topic)
+	}
+	if filter.Type != "" {
+		add("type = $%d", filter.Type)
+	}
+	if len(filter.Headers) != 0 {
+		add("headers @> $%d", filter.Headers)
+	}
+
+	return conds, args
+}
+
+// whereClause joins the conditions into a WHERE clause, or returns an empty string
+func whereClause(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+
+	return " WHERE " + strings.Join(conds, " AND ")
+}
+
+// orderClause returns the ORDER BY clause for the sort, ties are broken by ID
+func orderClause(sort models.Sort) string {
+	column, ok := sortColumns[sort.Field]
+	if !ok {
+		column = "id"
+	}
+
+	dir := "ASC"
+	if sort.Desc {
+		dir = "DESC"
+	}
+
+	switch column {
+	case "id":
+		return " ORDER BY id " + dir
+	case "sent_at":
+		// unsent messages come last in both directions
+		return fmt.Sprintf(" ORDER BY sent_at %s NULLS LAST, id %s", dir, dir)
+	default:
+		// matches the (column, id) indexes in both directions
+		return fmt.Sprintf(" ORDER BY %s %s, id %s", column, dir, dir)
+	}
+}
@@ -0,0 +1,97 @@
+package bdkeeper
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/wurt83ow/gophstream/internal/models"
+)
+
+func TestMessageConditions(t *testing.T) {
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+	minID, maxID := 10, 20
+
+	tests := []struct {
+		name      string
+		filter    models.Filter
+		args      []any
+		wantWhere string
+		wantArgs  []any
+	}{
+		{name: "empty"},
+		{
+			name:      "statuses",
+			filter:    models.Filter{Statuses: []models.MessageStatus{models.StatusQueued, models.StatusFailed}},
+			wantWhere: " WHERE status = ANY($1)",
+			wantArgs:  []any{[]string{"queued", "failed"}},
+		},
+		{
+			name:      "ranges",
+			filter:    models.Filter{CreatedFrom: &from, CreatedTo: &to, MinID: &minID, MaxID: &maxID},
+			wantWhere: " WHERE created_at >= $1 AND created_at < $2 AND id >= $3 AND id <= $4",
+			wantArgs:  []any{from, to, 10, 20},
+		},
+		{
+			name:      "contains escapes wildcards",
+			filter:    models.Filter{Contains: `50%_off\`},
+			wantWhere: " WHERE content ILIKE '%' || $1 || '%'",
+			wantArgs:  []any{`50\%\_off\\`},
+		},
+		{
+			name:      "search",
+			filter:    models.Filter{Search: "order -cancelled"},
+			wantWhere: " WHERE to_tsvector('simple', content) @@ websearch_to_tsquery('simple', $1)",
+			wantArgs:  []any{"order -cancelled"},
+		},
+		{
+			name:      "topic, type and headers",
+			filter:    models.Filter{Topic: "orders", Type: "created", Headers: map[string]string{"tenant": "a"}},
+			wantWhere: " WHERE topic = $1 AND type = $2 AND headers @> $3",
+			wantArgs:  []any{"orders", "created", map[string]string{"tenant": "a"}},
+		},
+		{
+			name:      "numbered after existing args",
+			filter:    models.Filter{Topic: "orders"},
+			args:      []any{"owner"},
+			wantWhere: " WHERE topic = $2",
+			wantArgs:  []any{"owner", "orders"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conds, args := messageConditions(tt.filter, tt.args)
+			if where := whereClause(conds); where != tt.wantWhere {
+				t.Errorf("where = %q, want %q", where, tt.wantWhere)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("args = %#v, want %#v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestOrderClause(t *testing.T) {
+	tests := []struct {
+		sort models.Sort
+		want string
+	}{
+		{sort: models.Sort{}, want: " ORDER BY id ASC"},
+		{sort: models.Sort{Field: models.SortByID, Desc: true}, want: " ORDER BY id DESC"},
+		{sort: models.Sort{Field: models.SortByCreatedAt}, want: " ORDER BY created_at ASC, id ASC"},
+		{sort: models.Sort{Field: models.SortByStatus, Desc: true}, want: " ORDER BY status DESC, id DESC"},
+		{sort: models.Sort{Field: models.SortByAttempts}, want: " ORDER BY attempts ASC, id ASC"},
+		{sort: models.Sort{Field: models.SortBySentAt, Desc: true}, want: " ORDER BY sent_at DESC NULLS LAST, id DESC"},
+		{sort: models.Sort{Field: "content; DROP TABLE messages"}, want: " ORDER BY id ASC"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.sort.Field), func(t *testing.T) {
+			if got := orderClause(tt.sort); got != tt.want {
+				t.Errorf("orderClause(%+v) = %q, want %q", tt.sort, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package bdkeeper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wurt83ow/gophstream/internal/models"
+)
+
+// idempotencyPurgeBatch is the number of expired keys removed with every new key,
+// so the table is cleaned up at the rate keys are created
+const idempotencyPurgeBatch = 10
+
+// reserveIdempotencyKey stores a new idempotency key inside the caller's transaction.
+// An expired key with the same value is replaced. If the key is still live it is
+// not changed and the stored record is returned instead. A request with the same
+// key waits on the insert until the transaction holding the key ends, so the
+// record it gets back has been completed by that transaction.
+func reserveIdempotencyKey(ctx context.Context, tx pgx.Tx, record models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	purge := `
+    DELETE FROM idempotency_keys
+    WHERE key IN (
+        SELECT key FROM idempotency_keys
+        WHERE expires_at < now()
+        LIMIT $1
+        FOR UPDATE SKIP LOCKED
+    )`
+
+	if _, err := tx.Exec(ctx, purge, idempotencyPurgeBatch); err != nil {
+		return nil, fmt.Errorf("failed to purge idempotency keys: %w", err)
+	}
+
+	// a concurrent request with the same key waits here until the first one commits
+	query := `
+    INSERT INTO idempotency_keys (key, request_hash, status_code, expires_at)
+    VALUES ($1, $2, $3, $4)
+    ON CONFLICT (key) DO UPDATE
+    SET request_hash = EXCLUDED.request_hash, status_code = EXCLUDED.status_code,
+        message_id = NULL, response = NULL, created_at = now(), expires_at = EXCLUDED.expires_at
+    WHERE idempotency_keys.expires_at <= now()
+    RETURNING key`
+
+	var key string
+	err := tx.QueryRow(ctx, query, record.Key, record.RequestHash, record.StatusCode, record.ExpiresAt).Scan(&key)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("failed to store idempotency key: %w", err)
+	}
+
+	query = `
+    SELECT key, request_hash, COALESCE(message_id, 0), status_code, response, expires_at
+    FROM idempotency_keys
+    WHERE key = $1`
+
+	var stored models.IdempotencyRecord
+	err = tx.QueryRow(ctx, query, record.Key).Scan(&stored.Key, &stored.RequestHash,
+		&stored.MessageID, &stored.StatusCode, &stored.Response, &stored.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read idempotency key: %w", err)
+	}
+
+	return &stored, nil
+}
+
+// completeIdempotencyKey stores the message created for the key and the response body
+func completeIdempotencyKey(ctx context.Context, tx pgx.Tx, key string, messageID int, response []byte) error {
+	query := `UPDATE idempotency_keys SET message_id = $1, response = $2 WHERE key = $3`
+
+	if _, err := tx.Exec(ctx, query, messageID, response, key); err != nil {
+		return fmt.Errorf("failed to complete idempotency key: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package bdkeeper
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// registerPoolMetrics exposes the statistics of the connection pool, read on every scrape
+func registerPoolMetrics(pool *pgxpool.Pool) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "gophstream_db_pool_acquired_conns",
+		Help: "Connections currently in use.",
+	}, func() float64 { return float64(pool.Stat().AcquiredConns()) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "gophstream_db_pool_idle_conns",
+		Help: "Connections currently idle.",
+	}, func() float64 { return float64(pool.Stat().IdleConns()) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "gophstream_db_pool_total_conns",
+		Help: "Connections currently open, including the ones being established.",
+	}, func() float64 { return float64(pool.Stat().TotalConns()) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "gophstream_db_pool_max_conns",
+		Help: "Maximum size of the pool.",
+	}, func() float64 { return float64(pool.Stat().MaxConns()) })
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "gophstream_db_pool_acquires_total",
+		Help: "Connections acquired from the pool.",
+	}, func() float64 { return float64(pool.Stat().AcquireCount()) })
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "gophstream_db_pool_empty_acquires_total",
+		Help: "Acquires that had to wait for a connection because the pool was empty.",
+	}, func() float64 { return float64(pool.Stat().EmptyAcquireCount()) })
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "gophstream_db_pool_canceled_acquires_total",
+		Help: "Acquires canceled by their context.",
+	}, func() float64 { return float64(pool.Stat().CanceledAcquireCount()) })
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "gophstream_db_pool_acquire_wait_seconds_total",
+		Help: "Total time spent waiting for connections.",
+	}, func() float64 { return pool.Stat().AcquireDuration().Seconds() })
+}
@@ -0,0 +1,331 @@
+package bdkeeper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wurt83ow/gophstream/internal/models"
+	"go.uber.org/zap"
+)
+
+// insertOutbox writes the outbox record for a message inside the caller's transaction
+func insertOutbox(ctx context.Context, tx pgx.Tx, message models.Message) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox payload: %w", err)
+	}
+
+	query := `INSERT INTO outbox (message_id, payload, state) VALUES ($1, $2, $3)`
+	_, err = tx.Exec(ctx, query, message.ID, payload, models.OutboxPending)
+
+	return err
+}
+
+// ClaimOutbox leases up to limit pending outbox records to the given owner.
+// Records whose lease has expired are claimed again, so a relay that crashed
+// between sending and acknowledging does not lose them.
+func (kp *BDKeeper) ClaimOutbox(ctx context.Context, owner string, limit int, lease time.Duration) ([]models.OutboxRecord, error) {
+	query := `
+    UPDATE outbox o
+    SET
+        state = $1,
+        claimed_by = $2,
+        lease_until = now() + make_interval(secs => $3),
+        attempts = o.attempts + 1
+    WHERE o.id IN (
+        SELECT id
+        FROM outbox
+        WHERE (state = $4 AND available_at <= now()) OR (state = $1 AND lease_until < now())
+        ORDER BY id
+        LIMIT $5
+        FOR UPDATE SKIP LOCKED)
+    RETURNING` + outboxColumns
+
+	return kp.claimOutbox(ctx, query, models.OutboxClaimed, owner, lease.Seconds(), models.OutboxPending, limit)
+}
+
+// ClaimAbandonedOutbox renews the lease of the outbox records still claimed by
+// owner, without waiting for the lease to expire. It is used on startup to take
+// over the records of the previous process of a relay with a stable ID, so the
+// ID must not be used by two relays at the same time.
+func (kp *BDKeeper) ClaimAbandonedOutbox(ctx context.Context, owner string, limit int, lease time.Duration) ([]models.OutboxRecord, error) {
+	query := `
+    UPDATE outbox o
+    SET
+        claimed_by = $2,
+        lease_until = now() + make_interval(secs => $3)
+    WHERE o.id IN (
+        SELECT id
+        FROM outbox
+        WHERE state = $1 AND claimed_by = $2
+        ORDER BY id
+        LIMIT $4
+        FOR UPDATE SKIP LOCKED)
+    RETURNING` + outboxColumns
+
+	return kp.claimOutbox(ctx, query, models.OutboxClaimed, owner, lease.Seconds(), limit)
+}
+
+// outboxColumns is the list of columns returned for a models.OutboxRecord
+const outboxColumns = `
+        o.id,
+        o.message_id,
+        o.payload,
+        o.state,
+        o.attempts,
+        o.available_at,
+        o.last_error,
+        o.claimed_by,
+        o.lease_until,
+        o.created_at`
+
+// claimOutbox runs a claiming query returning outboxColumns and moves the
+// messages of the claimed records to the in_flight status in the same transaction
+func (kp *BDKeeper) claimOutbox(ctx context.Context, query string, args ...any) ([]models.OutboxRecord, error) {
+	tx, err := kp.pool.Begin(ctx)
+	if err != nil {
+		kp.log.Info("Error starting transaction: ", zap.Error(err))
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		kp.log.Info("Error claiming outbox records: ", zap.Error(err))
+		return nil, err
+	}
+
+	records, err := pgx.CollectRows(rows, scanOutboxRecord)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim outbox records: %w", err)
+	}
+
+	transitions := make([]models.Transition, len(records))
+	for i, r := range records {
+		transitions[i] = models.Transition{ID: r.MessageID, To: models.StatusInFlight}
+	}
+	if _, err := transitionMessages(ctx, tx, transitions); err != nil {
+		kp.log.Info("Error updating messages status in database: ", zap.Error(err))
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		kp.log.Info("Error committing transaction: ", zap.Error(err))
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// scanOutboxRecord scans a claimed outbox record
+func scanOutboxRecord(row pgx.CollectableRow) (models.OutboxRecord, error) {
+	var r models.OutboxRecord
+	var lastError, claimedBy *string
+
+	err := row.Scan(&r.ID, &r.MessageID, &r.Payload, &r.State, &r.Attempts,
+		&r.AvailableAt, &lastError, &claimedBy, &r.LeaseUntil, &r.CreatedAt)
+	if lastError != nil {
+		r.LastError = *lastError
+	}
+	if claimedBy != nil {
+		r.ClaimedBy = *claimedBy
+	}
+
+	return r, err
+}
+
+// MarkOutboxDelivered marks outbox records claimed by owner as delivered and
+// moves their messages to the sent status with their Kafka partition and offset
+// in the same transaction.
+func (kp *BDKeeper) MarkOutboxDelivered(ctx context.Context, owner string, deliveries []models.Delivery) error {
+	tx, err := kp.pool.Begin(ctx)
+	if err != nil {
+		kp.log.Info("Error starting transaction: ", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+    UPDATE outbox
+    SET state = $1, delivered_at = now(), lease_until = NULL
+    WHERE id = ANY($2) AND state = $3 AND claimed_by = $4
+    RETURNING id, message_id`
+
+	byOutboxID := make(map[int64]models.Delivery, len(deliveries))
+	ids := make([]int64, len(deliveries))
+	for i, d := range deliveries {
+		byOutboxID[d.OutboxID] = d
+		ids[i] = d.OutboxID
+	}
+
+	rows, err := tx.Query(ctx, query, models.OutboxDelivered, ids, models.OutboxClaimed, owner)
+	if err != nil {
+		kp.log.Info("Error marking outbox records delivered: ", zap.Error(err))
+		return err
+	}
+
+	var transitions []models.Transition
+	var outboxID int64
+	var messageID int
+	_, err = pgx.ForEachRow(rows, []any{&outboxID, &messageID}, func() error {
+		d := byOutboxID[outboxID]
+		transitions = append(transitions, models.Transition{
+			ID:        messageID,
+			To:        models.StatusSent,
+			Partition: &d.Partition,
+			Offset:    &d.Offset,
+		})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox records delivered: %w", err)
+	}
+
+	if _, err := transitionMessages(ctx, tx, transitions); err != nil {
+		kp.log.Info("Error updating messages status in database: ", zap.Error(err))
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		kp.log.Info("Error committing transaction: ", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// FailOutbox records failed deliveries of outbox records claimed by owner.
+// Records to be retried return to the pending state and become available
+// again at their RetryAt time; dead records are never claimed again.
+// The status of their messages is updated in the same transaction.
+func (kp *BDKeeper) FailOutbox(ctx context.Context, owner string, failures []models.DeliveryFailure) error {
+	retryQuery := `
+    UPDATE outbox
+    SET state = $1, claimed_by = NULL, lease_until = NULL, available_at = $2, last_error = $3
+    WHERE id = $4 AND state = $5 AND claimed_by = $6
+    RETURNING message_id`
+
+	deadQuery := `
+    UPDATE outbox
+    SET state = $1, claimed_by = NULL, lease_until = NULL, last_error = $2
+    WHERE id = $3 AND state = $4 AND claimed_by = $5
+    RETURNING message_id`
+
+	tx, err := kp.pool.Begin(ctx)
+	if err != nil {
+		kp.log.Info("Error starting transaction: ", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	batch := &pgx.Batch{}
+	for _, f := range failures {
+		if f.Dead {
+			batch.Queue(deadQuery, models.OutboxDead, f.Error, f.OutboxID, models.OutboxClaimed, owner)
+		} else {
+			batch.Queue(retryQuery, models.OutboxPending, f.RetryAt, f.Error, f.OutboxID, models.OutboxClaimed, owner)
+		}
+	}
+
+	results := tx.SendBatch(ctx, batch)
+
+	var transitions []models.Transition
+	for _, f := range failures {
+		var messageID int
+		err := results.QueryRow().Scan(&messageID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			// the lease expired and the record was claimed by another relay
+			continue
+		}
+		if err != nil {
+			results.Close()
+			kp.log.Info("Error recording outbox failures: ", zap.Error(err))
+			return err
+		}
+
+		to := models.StatusFailed
+		if f.Dead {
+			to = models.StatusDead
+		}
+		transitions = append(transitions, models.Transition{
+			ID:         messageID,
+			To:         to,
+			Error:      f.Error,
+			ErrorClass: f.Class,
+		})
+	}
+	if err := results.Close(); err != nil {
+		kp.log.Info("Error recording outbox failures: ", zap.Error(err))
+		return err
+	}
+
+	if _, err := transitionMessages(ctx, tx, transitions); err != nil {
+		kp.log.Info("Error updating messages status in database: ", zap.Error(err))
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		kp.log.Info("Error committing transaction: ", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// ReleaseOutbox returns the outbox records still claimed by owner to the pending
+// state, available again right away, and moves their messages to the failed
+// status with the given reason. It is used when the relay shuts down before the
+// delivery of the records was confirmed. It returns the number of messages
+// that were moved.
+func (kp *BDKeeper) ReleaseOutbox(ctx context.Context, owner, reason string) (int, error) {
+	tx, err := kp.pool.Begin(ctx)
+	if err != nil {
+		kp.log.Info("Error starting transaction: ", zap.Error(err))
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+    UPDATE outbox
+    SET state = $1, claimed_by = NULL, lease_until = NULL, available_at = now(), last_error = $2
+    WHERE state = $3 AND claimed_by = $4
+    RETURNING message_id`
+
+	rows, err := tx.Query(ctx, query, models.OutboxPending, reason, models.OutboxClaimed, owner)
+	if err != nil {
+		kp.log.Info("Error releasing outbox records: ", zap.Error(err))
+		return 0, err
+	}
+
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return 0, fmt.Errorf("failed to release outbox records: %w", err)
+	}
+
+	transitions := make([]models.Transition, len(ids))
+	for i, id := range ids {
+		transitions[i] = models.Transition{
+			ID:         id,
+			To:         models.StatusFailed,
+			Error:      reason,
+			ErrorClass: models.ErrorClassShutdown,
+		}
+	}
+
+	applied, err := transitionMessages(ctx, tx, transitions)
+	if err != nil {
+		kp.log.Info("Error updating messages status in database: ", zap.Error(err))
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		kp.log.Info("Error committing transaction: ", zap.Error(err))
+		return 0, err
+	}
+
+	return len(applied), nil
+}
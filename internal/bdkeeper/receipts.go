@@ -0,0 +1,26 @@
+package bdkeeper
+
+import (
+	"context"
+
+	"github.com/wurt83ow/gophstream/internal/models"
+	"go.uber.org/zap"
+)
+
+// InsertReceipt records that a message was consumed from Kafka.
+// It returns false if a receipt for the same topic position already exists.
+func (kp *BDKeeper) InsertReceipt(ctx context.Context, receipt models.Receipt) (bool, error) {
+	query := `
+    INSERT INTO message_receipts (message_id, topic, kafka_partition, kafka_offset, consumer_group, consumed_at)
+    VALUES ($1, $2, $3, $4, $5, $6)
+    ON CONFLICT (consumer_group, topic, kafka_partition, kafka_offset) DO NOTHING`
+
+	tag, err := kp.pool.Exec(ctx, query, receipt.MessageID, receipt.Topic, receipt.Partition,
+		receipt.Offset, receipt.ConsumerGroup, receipt.ConsumedAt)
+	if err != nil {
+		kp.log.Info("Error inserting message receipt to database: ", zap.Error(err))
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
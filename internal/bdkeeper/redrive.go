@@ -0,0 +1,95 @@
+package bdkeeper
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wurt83ow/gophstream/internal/models"
+	"go.uber.org/zap"
+)
+
+// FindRedriveCandidates returns the IDs of the messages matching the redrive filter, oldest first
+func (kp *BDKeeper) FindRedriveCandidates(ctx context.Context, filter models.RedriveFilter, limit int) ([]int, error) {
+	conds := []string{"status = ANY($1)"}
+	args := []any{statusStrings(filter.Statuses)}
+
+	if filter.MessageID != nil {
+		args = append(args, *filter.MessageID)
+		conds = append(conds, fmt.Sprintf("id = $%d", len(args)))
+	}
+	if filter.From != nil {
+		args = append(args, *filter.From)
+		conds = append(conds, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if filter.To != nil {
+		args = append(args, *filter.To)
+		conds = append(conds, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+	if filter.ErrorContains != "" {
+		args = append(args, filter.ErrorContains)
+		conds = append(conds, fmt.Sprintf("strpos(last_error, $%d) > 0", len(args)))
+	}
+	args = append(args, limit)
+
+	query := `
+    SELECT id
+    FROM messages
+    WHERE ` + strings.Join(conds, " AND ") + `
+    ORDER BY id
+    LIMIT ` + fmt.Sprintf("$%d", len(args))
+
+	rows, err := kp.pool.Query(ctx, query, args...)
+	if err != nil {
+		kp.log.Info("Error finding messages to redrive: ", zap.Error(err))
+		return nil, err
+	}
+
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return nil, fmt.Errorf("failed to find messages to redrive: %w", err)
+	}
+
+	return ids, nil
+}
+
+// RedriveMessage resets the delivery state of a failed or dead-lettered
+// message and makes its outbox record available to the relay immediately.
+// It returns false if the message is not in a status that can be redriven.
+func (kp *BDKeeper) RedriveMessage(ctx context.Context, id int) (bool, error) {
+	tx, err := kp.pool.Begin(ctx)
+	if err != nil {
+		kp.log.Info("Error starting transaction: ", zap.Error(err))
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	applied, err := transitionMessages(ctx, tx, []models.Transition{{ID: id, To: models.StatusQueued}})
+	if err != nil {
+		kp.log.Info("Error updating messages status in database: ", zap.Error(err))
+		return false, err
+	}
+	if len(applied) == 0 {
+		return false, nil
+	}
+
+	query := `
+    UPDATE outbox
+    SET state = $1, attempts = 0, available_at = now(), last_error = NULL,
+        claimed_by = NULL, lease_until = NULL, delivered_at = NULL
+    WHERE message_id = $2 AND state = ANY($3)`
+
+	states := []string{string(models.OutboxPending), string(models.OutboxDead)}
+	if _, err := tx.Exec(ctx, query, models.OutboxPending, id, states); err != nil {
+		kp.log.Info("Error resetting outbox record: ", zap.Error(err))
+		return false, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		kp.log.Info("Error committing transaction: ", zap.Error(err))
+		return false, err
+	}
+
+	return true, nil
+}
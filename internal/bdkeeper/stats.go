@@ -0,0 +1,115 @@
+package bdkeeper
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wurt83ow/gophstream/internal/models"
+	"go.uber.org/zap"
+)
+
+// GetStats computes the statistics of processed messages with aggregate queries sent in a single round trip
+func (kp *BDKeeper) GetStats(ctx context.Context, query models.StatsQuery) (models.Stats, error) {
+	now := time.Now()
+	since := now.Add(-query.Window)
+	step := fmt.Sprintf("1 %s", query.Bucket)
+
+	batch := &pgx.Batch{}
+	batch.Queue(`SELECT status, COUNT(*) FROM messages GROUP BY status`)
+	// every bucket of the window is returned, including the empty ones
+	batch.Queue(`
+    SELECT b.bucket, COUNT(m.id)
+    FROM generate_series(date_trunc($1, $2::timestamptz), date_trunc($1, $3::timestamptz), $4::interval) AS b(bucket)
+    LEFT JOIN messages m
+        ON m.sent_at >= b.bucket AND m.sent_at < b.bucket + $4::interval AND m.sent_at >= $2
+    GROUP BY b.bucket
+    ORDER BY b.bucket`, string(query.Bucket), since, now, step)
+	batch.Queue(`
+    SELECT COUNT(*),
+        COALESCE(AVG(EXTRACT(EPOCH FROM sent_at - created_at)), 0),
+        COALESCE(percentile_cont(0.95) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM sent_at - created_at)), 0)
+    FROM messages
+    WHERE sent_at >= $1`, since)
+	batch.Queue(`
+    SELECT COALESCE(error_class, $1), COUNT(*)
+    FROM messages
+    WHERE status IN ('failed', 'dead')
+    GROUP BY 1`, string(models.ErrorClassUnknown))
+
+	results := kp.pool.SendBatch(ctx, batch)
+	defer results.Close()
+
+	stats := models.Stats{
+		Window:          query.Window.String(),
+		Bucket:          query.Bucket,
+		ByStatus:        make(map[models.MessageStatus]int64),
+		FailuresByClass: make(map[models.ErrorClass]int64),
+		GeneratedAt:     now,
+	}
+
+	if err := collectStats(results, func(row pgx.Row) error {
+		var status models.MessageStatus
+		var count int64
+		if err := row.Scan(&status, &count); err != nil {
+			return err
+		}
+		stats.ByStatus[status] = count
+		stats.Total += count
+		return nil
+	}); err != nil {
+		kp.log.Info("Error counting messages by status: ", zap.Error(err))
+		return models.Stats{}, err
+	}
+
+	if err := collectStats(results, func(row pgx.Row) error {
+		var point models.ThroughputPoint
+		if err := row.Scan(&point.Time, &point.Sent); err != nil {
+			return err
+		}
+		stats.Throughput = append(stats.Throughput, point)
+		return nil
+	}); err != nil {
+		kp.log.Info("Error computing message throughput: ", zap.Error(err))
+		return models.Stats{}, err
+	}
+
+	err := results.QueryRow().Scan(&stats.Sent, &stats.AvgLatencySeconds, &stats.P95LatencySeconds)
+	if err != nil {
+		kp.log.Info("Error computing message latency: ", zap.Error(err))
+		return models.Stats{}, err
+	}
+
+	if err := collectStats(results, func(row pgx.Row) error {
+		var class models.ErrorClass
+		var count int64
+		if err := row.Scan(&class, &count); err != nil {
+			return err
+		}
+		stats.FailuresByClass[class] = count
+		return nil
+	}); err != nil {
+		kp.log.Info("Error counting failures by error class: ", zap.Error(err))
+		return models.Stats{}, err
+	}
+
+	return stats, nil
+}
+
+// collectStats passes every row of the next result of the batch to fn
+func collectStats(results pgx.BatchResults, fn func(pgx.Row) error) error {
+	rows, err := results.Query()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
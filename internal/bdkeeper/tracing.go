@@ -0,0 +1,98 @@
+package bdkeeper
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/wurt83ow/gophstream/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// queryTracer records a span for every query, batch and copy run on the pool,
+// as a child of the span in the context the query was started with
+type queryTracer struct{}
+
+// spanKey is the context key of the span started by queryTracer, kept apart
+// from the span of the caller so that only the tracer's own span is ended
+type spanKey struct{}
+
+var (
+	_ pgx.QueryTracer    = queryTracer{}
+	_ pgx.BatchTracer    = queryTracer{}
+	_ pgx.CopyFromTracer = queryTracer{}
+)
+
+// start starts a client span and stores it in the returned context
+func start(ctx context.Context, name string, attrs ...attribute.KeyValue) context.Context {
+	attrs = append([]attribute.KeyValue{attribute.String("db.system", "postgresql")}, attrs...)
+	ctx, span := tracing.Tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// spanFrom returns the span stored by start, or nil if there is none
+func spanFrom(ctx context.Context) trace.Span {
+	span, _ := ctx.Value(spanKey{}).(trace.Span)
+	return span
+}
+
+// end ends the span stored by start with the result of the command
+func end(ctx context.Context, tag pgconn.CommandTag, err error) {
+	span := spanFrom(ctx)
+	if span == nil {
+		return
+	}
+	span.SetAttributes(attribute.Int64("db.rows_affected", tag.RowsAffected()))
+	tracing.RecordError(span, err)
+	span.End()
+}
+
+func (queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	statement := compactSQL(data.SQL)
+	return start(ctx, "db "+operation(statement), attribute.String("db.statement", statement))
+}
+
+func (queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	end(ctx, data.CommandTag, data.Err)
+}
+
+func (queryTracer) TraceBatchStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchStartData) context.Context {
+	return start(ctx, "db batch", attribute.Int("db.batch.size", data.Batch.Len()))
+}
+
+func (queryTracer) TraceBatchQuery(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchQueryData) {
+	if span := spanFrom(ctx); span != nil {
+		tracing.RecordError(span, data.Err)
+	}
+}
+
+func (queryTracer) TraceBatchEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchEndData) {
+	if span := spanFrom(ctx); span != nil {
+		tracing.RecordError(span, data.Err)
+		span.End()
+	}
+}
+
+func (queryTracer) TraceCopyFromStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromStartData) context.Context {
+	table := data.TableName.Sanitize()
+	return start(ctx, "db COPY "+table, attribute.String("db.sql.table", table))
+}
+
+func (queryTracer) TraceCopyFromEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	end(ctx, data.CommandTag, data.Err)
+}
+
+// compactSQL collapses the indentation of a query into single spaces
+func compactSQL(sql string) string {
+	return strings.Join(strings.Fields(sql), " ")
+}
+
+// operation returns the SQL command of a query, e.g. SELECT
+func operation(statement string) string {
+	op, _, _ := strings.Cut(statement, " ")
+	return strings.ToUpper(op)
+}
@@ -0,0 +1,45 @@
+package bdkeeper
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestQueryTracerEndsOwnSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previous)
+
+	ctx, caller := provider.Tracer("test").Start(context.Background(), "caller")
+	defer caller.End()
+
+	var tracer queryTracer
+
+	// a query that was not started by the tracer leaves the span of the caller alone
+	tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{Err: errors.New("failed")})
+	if n := len(recorder.Ended()); n != 0 {
+		t.Fatalf("TraceQueryEnd() without a query span ended %d spans, want 0", n)
+	}
+
+	queryCtx := tracer.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: "select\n\t1"})
+	tracer.TraceQueryEnd(queryCtx, nil, pgx.TraceQueryEndData{})
+
+	ended := recorder.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("TraceQueryEnd() ended %d spans, want 1", len(ended))
+	}
+	if ended[0].Name() != "db SELECT" {
+		t.Errorf("ended span %q, want %q", ended[0].Name(), "db SELECT")
+	}
+	if ended[0].Parent().SpanID() != caller.SpanContext().SpanID() {
+		t.Errorf("query span is not a child of the caller span")
+	}
+}
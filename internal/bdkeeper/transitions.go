@@ -0,0 +1,92 @@
+package bdkeeper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wurt83ow/gophstream/internal/models"
+)
+
+// batchSender is implemented by both the connection pool and a transaction
+type batchSender interface {
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+}
+
+// transitionStatement builds the guarded UPDATE for a single transition.
+// The row is only updated if its current status is allowed to move to t.To.
+func transitionStatement(t models.Transition) (string, []any, error) {
+	var set string
+	args := []any{t.To, t.ID, statusStrings(t.To.AllowedFrom())}
+
+	switch t.To {
+	case models.StatusQueued:
+		set = `attempts = 0, last_error = NULL, error_class = NULL, sent_at = NULL,
+        kafka_partition = NULL, kafka_offset = NULL`
+	case models.StatusInFlight:
+		set = `attempts = attempts + 1`
+	case models.StatusSent:
+		set = `sent_at = now(), last_error = NULL, error_class = NULL, kafka_partition = $4, kafka_offset = $5`
+		args = append(args, t.Partition, t.Offset)
+	case models.StatusFailed, models.StatusDead:
+		set = `last_error = $4, error_class = $5`
+		args = append(args, t.Error, t.ErrorClass)
+	default:
+		return "", nil, fmt.Errorf("unknown message status %q", t.To)
+	}
+
+	query := `
+    UPDATE messages
+    SET status = $1, ` + set + `
+    WHERE id = $2 AND status = ANY($3)
+    RETURNING id`
+
+	return query, args, nil
+}
+
+// statusStrings converts statuses to their database representation
+func statusStrings(statuses []models.MessageStatus) []string {
+	res := make([]string, len(statuses))
+	for i, s := range statuses {
+		res[i] = string(s)
+	}
+
+	return res
+}
+
+// transitionMessages applies the transitions in a single round trip and
+// returns the IDs of the messages that were moved. Transitions rejected by
+// the status guard are skipped.
+func transitionMessages(ctx context.Context, db batchSender, transitions []models.Transition) ([]int, error) {
+	if len(transitions) == 0 {
+		return nil, nil
+	}
+
+	batch := &pgx.Batch{}
+	for _, t := range transitions {
+		query, args, err := transitionStatement(t)
+		if err != nil {
+			return nil, err
+		}
+		batch.Queue(query, args...)
+	}
+
+	results := db.SendBatch(ctx, batch)
+	defer results.Close()
+
+	applied := make([]int, 0, len(transitions))
+	for range transitions {
+		var id int
+		err := results.QueryRow().Scan(&id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to transition messages: %w", err)
+		}
+		applied = append(applied, id)
+	}
+
+	return applied, nil
+}
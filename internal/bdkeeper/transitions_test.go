@@ -0,0 +1,75 @@
+package bdkeeper
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wurt83ow/gophstream/internal/models"
+)
+
+func TestTransitionStatement(t *testing.T) {
+	partition, offset := 2, int64(40)
+
+	tests := []struct {
+		name       string
+		transition models.Transition
+		wantSet    string
+		wantFrom   []string
+		wantErr    bool
+	}{
+		{
+			name:       "queued",
+			transition: models.Transition{ID: 1, To: models.StatusQueued},
+			wantSet:    "attempts = 0",
+			wantFrom:   []string{"failed", "dead"},
+		},
+		{
+			name:       "in flight",
+			transition: models.Transition{ID: 1, To: models.StatusInFlight},
+			wantSet:    "attempts = attempts + 1",
+			wantFrom:   []string{"queued", "failed", "in_flight"},
+		},
+		{
+			name:       "sent",
+			transition: models.Transition{ID: 1, To: models.StatusSent, Partition: &partition, Offset: &offset},
+			wantSet:    "kafka_partition = $4, kafka_offset = $5",
+			wantFrom:   []string{"in_flight"},
+		},
+		{
+			name:       "failed",
+			transition: models.Transition{ID: 1, To: models.StatusFailed, Error: "timeout"},
+			wantSet:    "last_error = $4, error_class = $5",
+			wantFrom:   []string{"in_flight"},
+		},
+		{
+			name:       "dead",
+			transition: models.Transition{ID: 1, To: models.StatusDead, Error: "too large"},
+			wantSet:    "last_error = $4, error_class = $5",
+			wantFrom:   []string{"in_flight", "failed"},
+		},
+		{name: "unknown", transition: models.Transition{ID: 1, To: "unknown"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args, err := transitionStatement(tt.transition)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("transitionStatement() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if !strings.Contains(query, tt.wantSet) || !strings.Contains(query, "status = ANY($3)") {
+				t.Errorf("query %q does not set %q guarded by the current status", query, tt.wantSet)
+			}
+			if args[0] != tt.transition.To || args[1] != tt.transition.ID {
+				t.Errorf("args = %v, want the status and ID first", args)
+			}
+			if !reflect.DeepEqual(args[2], tt.wantFrom) {
+				t.Errorf("allowed from = %v, want %v", args[2], tt.wantFrom)
+			}
+		})
+	}
+}
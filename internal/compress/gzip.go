@@ -36,6 +36,22 @@ func (c *compressWriter) WriteHeader(statusCode int) {
 	c.w.WriteHeader(statusCode)
 }
 
+// Flush sends the data compressed so far, so that a streamed
+// response reaches the client before it is complete.
+func (c *compressWriter) Flush() {
+	if err := c.zw.Flush(); err != nil {
+		return
+	}
+	if f, ok := c.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the original http.ResponseWriter for http.ResponseController
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.w
+}
+
 // Closes gzip.Writer and sends all data from the buffer.
 func (c *compressWriter) Close() error {
 	return c.zw.Close()
@@ -11,8 +11,14 @@ import (
 
 type Options struct {
 	flagRunAddr, flagLogLevel, flagDataBaseDSN,
-	flagJWTSigningKey, flagConcurrency, flagTaskExecutionInterval,
-	flagUserUpdateInterval, flagDefaultEndTime, flagApiSystemAddress string
+	flagJWTSigningKey, flagConcurrency, flagTaskExecutionInterval, flagTaskTimeout,
+	flagQueueCapacity, flagQueuePolicy,
+	flagUserUpdateInterval, flagDefaultEndTime, flagApiSystemAddress,
+	flagRetryMaxAttempts, flagRetryBaseDelay, flagRetryMaxDelay, flagDeadLetterTopic,
+	flagRoutingRulesFile, flagIdempotencyTTL, flagMaxBatchSize, flagShutdownTimeout, flagRelayID string
+
+	kafka   kafkaFlags
+	tracing tracingFlags
 }
 
 func NewOptions() *Options {
@@ -30,11 +36,25 @@ func (o *Options) ParseFlags() {
 	regStringVar(&o.flagConcurrency, "c", getEnvOrDefault("CONCURRENCY", "5"), "Concurrency")
 	regStringVar(&o.flagDataBaseDSN, "d", getEnvOrDefault("DATABASE_URI", ""), "")
 	regStringVar(&o.flagTaskExecutionInterval, "i", getEnvOrDefault("TASK_EXECUTION_INTERVAL", "3000"), "Task execution interval in milliseconds")
+	regStringVar(&o.flagTaskTimeout, "task-timeout", getEnvOrDefault("TASK_TIMEOUT", "30s"), "maximum time a worker pool task may run")
+	regStringVar(&o.flagQueueCapacity, "queue-capacity", getEnvOrDefault("QUEUE_CAPACITY", "1000"), "number of tasks that can wait for a worker")
+	regStringVar(&o.flagQueuePolicy, "queue-policy", getEnvOrDefault("QUEUE_POLICY", "block"), "what to do with a task while the queue is full (block|reject|drop-oldest)")
 	regStringVar(&o.flagJWTSigningKey, "j", getEnvOrDefault("JWT_SIGNING_KEY", "test_key"), "jwt signing key")
 	regStringVar(&o.flagLogLevel, "l", getEnvOrDefault("LOG_LEVEL", "debug"), "log level")
 	regStringVar(&o.flagUserUpdateInterval, "u", getEnvOrDefault("USER_UPDATE_INTERVAL", "5m"), "user update interval")
 	regStringVar(&o.flagDefaultEndTime, "e", getEnvOrDefault("DEFAULT_END_TIME", "19:00"), "default end time")
 	regStringVar(&o.flagApiSystemAddress, "s", getEnvOrDefault("API_SYSTEM_ADDRESS", "localhost:8081"), "API system address")
+	regStringVar(&o.flagRetryMaxAttempts, "retry-max-attempts", getEnvOrDefault("RETRY_MAX_ATTEMPTS", "5"), "maximum number of attempts to send a message to Kafka")
+	regStringVar(&o.flagRetryBaseDelay, "retry-base-delay", getEnvOrDefault("RETRY_BASE_DELAY", "1s"), "delay before the first retry, doubled on every attempt")
+	regStringVar(&o.flagRetryMaxDelay, "retry-max-delay", getEnvOrDefault("RETRY_MAX_DELAY", "5m"), "maximum delay between retries")
+	regStringVar(&o.flagDeadLetterTopic, "dlq-topic", getEnvOrDefault("DEAD_LETTER_TOPIC", "message_topic.dlq"), "topic for messages that could not be delivered")
+	regStringVar(&o.flagRoutingRulesFile, "routing-rules", getEnvOrDefault("ROUTING_RULES_FILE", ""), "YAML or JSON file with topic routing rules")
+	regStringVar(&o.flagIdempotencyTTL, "idempotency-ttl", getEnvOrDefault("IDEMPOTENCY_TTL", "24h"), "how long the response to an Idempotency-Key is kept")
+	regStringVar(&o.flagMaxBatchSize, "max-batch-size", getEnvOrDefault("MAX_BATCH_SIZE", "1000"), "maximum number of messages in a batch request")
+	regStringVar(&o.flagShutdownTimeout, "shutdown-timeout", getEnvOrDefault("SHUTDOWN_TIMEOUT", "30s"), "time to stop all components after a termination signal")
+	regStringVar(&o.flagRelayID, "relay-id", getEnvOrDefault("RELAY_ID", ""), "identifier of this outbox relay, kept across restarts and unique among running relays")
+	o.regKafkaFlags()
+	o.regTracingFlags()
 
 	// parse the arguments passed to the server into registered variables
 	flag.Parse()
@@ -64,6 +84,18 @@ func (o *Options) TaskExecutionInterval() string {
 	return o.flagTaskExecutionInterval
 }
 
+func (o *Options) TaskTimeout() string {
+	return o.flagTaskTimeout
+}
+
+func (o *Options) QueueCapacity() string {
+	return o.flagQueueCapacity
+}
+
+func (o *Options) QueuePolicy() string {
+	return o.flagQueuePolicy
+}
+
 func (o *Options) UserUpdateInterval() string {
 	return o.flagUserUpdateInterval
 }
@@ -76,6 +108,42 @@ func (o *Options) ApiSystemAddress() string {
 	return o.flagApiSystemAddress
 }
 
+func (o *Options) RetryMaxAttempts() string {
+	return o.flagRetryMaxAttempts
+}
+
+func (o *Options) RetryBaseDelay() string {
+	return o.flagRetryBaseDelay
+}
+
+func (o *Options) RetryMaxDelay() string {
+	return o.flagRetryMaxDelay
+}
+
+func (o *Options) DeadLetterTopic() string {
+	return o.flagDeadLetterTopic
+}
+
+func (o *Options) RoutingRulesFile() string {
+	return o.flagRoutingRulesFile
+}
+
+func (o *Options) IdempotencyTTL() string {
+	return o.flagIdempotencyTTL
+}
+
+func (o *Options) MaxBatchSize() string {
+	return o.flagMaxBatchSize
+}
+
+func (o *Options) ShutdownTimeout() string {
+	return o.flagShutdownTimeout
+}
+
+func (o *Options) RelayID() string {
+	return o.flagRelayID
+}
+
 func regStringVar(p *string, name string, value string, usage string) {
 	if flag.Lookup(name) == nil {
 		flag.StringVar(p, name, value, usage)
@@ -0,0 +1,207 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// kafkaFlags holds the Kafka client settings
+type kafkaFlags struct {
+	brokers, topic, clientID, requiredAcks, compression, batchSize, batchBytes, batchTimeout, async, dedupe,
+	saslMechanism, saslUsername, saslPassword,
+	tlsEnabled, tlsCAFile, tlsCertFile, tlsKeyFile, tlsInsecureSkipVerify string
+}
+
+var (
+	kafkaRequiredAcks  = []string{"none", "one", "all"}
+	kafkaCompressions  = []string{"none", "gzip", "snappy", "lz4", "zstd"}
+	kafkaSASLMechanism = []string{"", "plain", "scram-sha-256", "scram-sha-512"}
+)
+
+// regKafkaFlags registers the Kafka command line flags
+func (o *Options) regKafkaFlags() {
+	k := &o.kafka
+
+	regStringVar(&k.brokers, "kafka-brokers", getEnvOrDefault("KAFKA_BROKERS", ""), "comma separated list of Kafka brokers")
+	regStringVar(&k.topic, "kafka-topic", getEnvOrDefault("KAFKA_TOPIC", "message_topic"), "default Kafka topic for messages")
+	regStringVar(&k.clientID, "kafka-client-id", getEnvOrDefault("KAFKA_CLIENT_ID", "gophstream"), "Kafka client ID")
+	regStringVar(&k.requiredAcks, "kafka-acks", getEnvOrDefault("KAFKA_REQUIRED_ACKS", "all"), "required acks (none, one, all)")
+	regStringVar(&k.compression, "kafka-compression", getEnvOrDefault("KAFKA_COMPRESSION", "none"), "compression codec (none, gzip, snappy, lz4, zstd)")
+	regStringVar(&k.batchSize, "kafka-batch-size", getEnvOrDefault("KAFKA_BATCH_SIZE", "100"), "maximum number of messages in a batch")
+	regStringVar(&k.batchBytes, "kafka-batch-bytes", getEnvOrDefault("KAFKA_BATCH_BYTES", "1048576"), "maximum size of a batch in bytes")
+	regStringVar(&k.batchTimeout, "kafka-batch-timeout", getEnvOrDefault("KAFKA_BATCH_TIMEOUT", "10ms"), "time limit to fill a batch")
+	regStringVar(&k.async, "kafka-async", getEnvOrDefault("KAFKA_ASYNC", "false"), "publish without waiting in batches, using the writer's delivery callbacks")
+	regStringVar(&k.dedupe, "kafka-dedupe", getEnvOrDefault("KAFKA_DEDUPE", "false"), "look up writes with an unknown outcome in the topic instead of sending them again (at-least-once with best-effort dedupe)")
+	regStringVar(&k.saslMechanism, "kafka-sasl-mechanism", getEnvOrDefault("KAFKA_SASL_MECHANISM", ""), "SASL mechanism (plain, scram-sha-256, scram-sha-512), empty to disable")
+	regStringVar(&k.saslUsername, "kafka-sasl-username", getEnvOrDefault("KAFKA_SASL_USERNAME", ""), "SASL username")
+	regStringVar(&k.saslPassword, "kafka-sasl-password", getEnvOrDefault("KAFKA_SASL_PASSWORD", ""), "SASL password")
+	regStringVar(&k.tlsEnabled, "kafka-tls", getEnvOrDefault("KAFKA_TLS_ENABLED", "false"), "connect to Kafka over TLS")
+	regStringVar(&k.tlsCAFile, "kafka-tls-ca", getEnvOrDefault("KAFKA_TLS_CA_FILE", ""), "CA certificate file for Kafka TLS")
+	regStringVar(&k.tlsCertFile, "kafka-tls-cert", getEnvOrDefault("KAFKA_TLS_CERT_FILE", ""), "client certificate file for Kafka TLS")
+	regStringVar(&k.tlsKeyFile, "kafka-tls-key", getEnvOrDefault("KAFKA_TLS_KEY_FILE", ""), "client key file for Kafka TLS")
+	regStringVar(&k.tlsInsecureSkipVerify, "kafka-tls-insecure", getEnvOrDefault("KAFKA_TLS_INSECURE_SKIP_VERIFY", "false"), "skip verification of the Kafka server certificate")
+}
+
+// ValidateKafka checks the Kafka settings and returns an error
+// listing every missing or invalid option
+func (o *Options) ValidateKafka() error {
+	k := o.kafka
+	var problems []string
+
+	if len(o.KafkaBrokers()) == 0 {
+		problems = append(problems, "KAFKA_BROKERS (-kafka-brokers) is missing")
+	}
+	if k.topic == "" {
+		problems = append(problems, "KAFKA_TOPIC (-kafka-topic) is missing")
+	}
+	if !oneOf(k.requiredAcks, kafkaRequiredAcks) {
+		problems = append(problems, fmt.Sprintf("KAFKA_REQUIRED_ACKS (-kafka-acks) must be one of %v, got %q", kafkaRequiredAcks, k.requiredAcks))
+	}
+	if !oneOf(k.compression, kafkaCompressions) {
+		problems = append(problems, fmt.Sprintf("KAFKA_COMPRESSION (-kafka-compression) must be one of %v, got %q", kafkaCompressions, k.compression))
+	}
+	if v, err := strconv.Atoi(k.batchSize); err != nil || v < 1 {
+		problems = append(problems, fmt.Sprintf("KAFKA_BATCH_SIZE (-kafka-batch-size) must be a positive number, got %q", k.batchSize))
+	}
+	if v, err := strconv.Atoi(k.batchBytes); err != nil || v < 1 {
+		problems = append(problems, fmt.Sprintf("KAFKA_BATCH_BYTES (-kafka-batch-bytes) must be a positive number, got %q", k.batchBytes))
+	}
+	if v, err := time.ParseDuration(k.batchTimeout); err != nil || v <= 0 {
+		problems = append(problems, fmt.Sprintf("KAFKA_BATCH_TIMEOUT (-kafka-batch-timeout) must be a positive duration, got %q", k.batchTimeout))
+	}
+	if _, err := strconv.ParseBool(k.async); err != nil {
+		problems = append(problems, fmt.Sprintf("KAFKA_ASYNC (-kafka-async) must be true or false, got %q", k.async))
+	}
+	if v, err := strconv.ParseBool(k.dedupe); err != nil {
+		problems = append(problems, fmt.Sprintf("KAFKA_DEDUPE (-kafka-dedupe) must be true or false, got %q", k.dedupe))
+	} else if v && k.requiredAcks != "all" {
+		problems = append(problems, "KAFKA_DEDUPE (-kafka-dedupe) requires KAFKA_REQUIRED_ACKS=all")
+	}
+
+	if !oneOf(k.saslMechanism, kafkaSASLMechanism) {
+		problems = append(problems, fmt.Sprintf("KAFKA_SASL_MECHANISM (-kafka-sasl-mechanism) must be one of %v, got %q", kafkaSASLMechanism[1:], k.saslMechanism))
+	} else if k.saslMechanism != "" {
+		if k.saslUsername == "" {
+			problems = append(problems, "KAFKA_SASL_USERNAME (-kafka-sasl-username) is missing")
+		}
+		if k.saslPassword == "" {
+			problems = append(problems, "KAFKA_SASL_PASSWORD (-kafka-sasl-password) is missing")
+		}
+	}
+
+	if _, err := strconv.ParseBool(k.tlsEnabled); err != nil {
+		problems = append(problems, fmt.Sprintf("KAFKA_TLS_ENABLED (-kafka-tls) must be true or false, got %q", k.tlsEnabled))
+	}
+	if _, err := strconv.ParseBool(k.tlsInsecureSkipVerify); err != nil {
+		problems = append(problems, fmt.Sprintf("KAFKA_TLS_INSECURE_SKIP_VERIFY (-kafka-tls-insecure) must be true or false, got %q", k.tlsInsecureSkipVerify))
+	}
+	if (k.tlsCertFile == "") != (k.tlsKeyFile == "") {
+		problems = append(problems, "KAFKA_TLS_CERT_FILE (-kafka-tls-cert) and KAFKA_TLS_KEY_FILE (-kafka-tls-key) must be set together")
+	}
+
+	if len(problems) != 0 {
+		return errors.New("invalid Kafka configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+
+	return nil
+}
+
+func (o *Options) KafkaBrokers() []string {
+	var brokers []string
+	for _, b := range strings.Split(o.kafka.brokers, ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			brokers = append(brokers, b)
+		}
+	}
+
+	return brokers
+}
+
+func (o *Options) KafkaTopic() string {
+	return o.kafka.topic
+}
+
+func (o *Options) KafkaClientID() string {
+	return o.kafka.clientID
+}
+
+func (o *Options) KafkaRequiredAcks() string {
+	return o.kafka.requiredAcks
+}
+
+func (o *Options) KafkaCompression() string {
+	return o.kafka.compression
+}
+
+func (o *Options) KafkaBatchSize() int {
+	v, _ := strconv.Atoi(o.kafka.batchSize)
+	return v
+}
+
+func (o *Options) KafkaBatchBytes() int {
+	v, _ := strconv.Atoi(o.kafka.batchBytes)
+	return v
+}
+
+func (o *Options) KafkaBatchTimeout() time.Duration {
+	v, _ := time.ParseDuration(o.kafka.batchTimeout)
+	return v
+}
+
+func (o *Options) KafkaAsync() bool {
+	v, _ := strconv.ParseBool(o.kafka.async)
+	return v
+}
+
+func (o *Options) KafkaDedupe() bool {
+	v, _ := strconv.ParseBool(o.kafka.dedupe)
+	return v
+}
+
+func (o *Options) KafkaSASLMechanism() string {
+	return o.kafka.saslMechanism
+}
+
+func (o *Options) KafkaSASLUsername() string {
+	return o.kafka.saslUsername
+}
+
+func (o *Options) KafkaSASLPassword() string {
+	return o.kafka.saslPassword
+}
+
+func (o *Options) KafkaTLSEnabled() bool {
+	v, _ := strconv.ParseBool(o.kafka.tlsEnabled)
+	return v
+}
+
+func (o *Options) KafkaTLSCAFile() string {
+	return o.kafka.tlsCAFile
+}
+
+func (o *Options) KafkaTLSCertFile() string {
+	return o.kafka.tlsCertFile
+}
+
+func (o *Options) KafkaTLSKeyFile() string {
+	return o.kafka.tlsKeyFile
+}
+
+func (o *Options) KafkaTLSInsecureSkipVerify() bool {
+	v, _ := strconv.ParseBool(o.kafka.tlsInsecureSkipVerify)
+	return v
+}
+
+// oneOf reports whether v is in the list of allowed values
+func oneOf(v string, allowed []string) bool {
+	for _, a := range allowed {
+		if v == a {
+			return true
+		}
+	}
+
+	return false
+}
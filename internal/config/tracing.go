@@ -0,0 +1,63 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// tracingFlags holds the tracing settings
+type tracingFlags struct {
+	exporter, otlpEndpoint, file, serviceName string
+}
+
+var tracingExporters = []string{"none", "otlp", "stdout"}
+
+// regTracingFlags registers the tracing command line flags
+func (o *Options) regTracingFlags() {
+	t := &o.tracing
+
+	regStringVar(&t.exporter, "tracing-exporter", getEnvOrDefault("TRACING_EXPORTER", "none"), "span exporter (none, otlp, stdout)")
+	regStringVar(&t.otlpEndpoint, "tracing-otlp-endpoint", getEnvOrDefault("TRACING_OTLP_ENDPOINT", "http://localhost:4318"), "OTLP/HTTP endpoint of the OpenTelemetry collector")
+	regStringVar(&t.file, "tracing-file", getEnvOrDefault("TRACING_FILE", ""), "file the stdout exporter appends spans to, empty for stdout")
+	regStringVar(&t.serviceName, "tracing-service-name", getEnvOrDefault("TRACING_SERVICE_NAME", "gophstream"), "service name reported with the spans")
+}
+
+// ValidateTracing checks the tracing settings and returns an error
+// listing every missing or invalid option
+func (o *Options) ValidateTracing() error {
+	t := o.tracing
+	var problems []string
+
+	if !oneOf(t.exporter, tracingExporters) {
+		problems = append(problems, fmt.Sprintf("TRACING_EXPORTER (-tracing-exporter) must be one of %v, got %q", tracingExporters, t.exporter))
+	}
+	if t.exporter == "otlp" && t.otlpEndpoint == "" {
+		problems = append(problems, "TRACING_OTLP_ENDPOINT (-tracing-otlp-endpoint) is missing")
+	}
+	if t.serviceName == "" {
+		problems = append(problems, "TRACING_SERVICE_NAME (-tracing-service-name) is missing")
+	}
+
+	if len(problems) != 0 {
+		return errors.New("invalid tracing configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+
+	return nil
+}
+
+func (o *Options) TracingExporter() string {
+	return o.tracing.exporter
+}
+
+func (o *Options) TracingOTLPEndpoint() string {
+	return o.tracing.otlpEndpoint
+}
+
+func (o *Options) TracingFile() string {
+	return o.tracing.file
+}
+
+func (o *Options) TracingServiceName() string {
+	return o.tracing.serviceName
+}
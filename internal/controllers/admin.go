@@ -0,0 +1,240 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/wurt83ow/gophstream/internal/models"
+	"github.com/wurt83ow/gophstream/internal/routing"
+	"github.com/wurt83ow/gophstream/internal/workerpool"
+	"go.uber.org/zap"
+)
+
+// maxRedriveBatch is the maximum number of messages redriven by a single bulk request
+const maxRedriveBatch = 10000
+
+// redriveJob is the kind of the jobs created by the redrive endpoints
+const redriveJob = "redrive"
+
+// AdminStorage interface for resetting the delivery state of messages
+type AdminStorage interface {
+	FindRedriveCandidates(context.Context, models.RedriveFilter, int) ([]int, error)
+	RedriveMessage(context.Context, int) error
+}
+
+// Pool interface for enqueueing background tasks
+type Pool interface {
+	AddTask(ctx context.Context, task *workerpool.Task) error
+}
+
+// Jobs interface for tracking the progress of background jobs
+type Jobs interface {
+	Create(kind string, total int) models.Job
+	Done(id string, err error)
+	Get(id string) (models.Job, bool)
+}
+
+// AdminController struct for handling operator requests
+type AdminController struct {
+	ctx     context.Context
+	storage AdminStorage
+	pool    Pool
+	jobs    Jobs
+	router  Router
+	log     Log
+}
+
+// NewAdminController creates a new AdminController instance
+func NewAdminController(ctx context.Context, storage AdminStorage, pool Pool, jobs Jobs, router Router, log Log) *AdminController {
+	return &AdminController{
+		ctx:     ctx,
+		storage: storage,
+		pool:    pool,
+		jobs:    jobs,
+		router:  router,
+		log:     log,
+	}
+}
+
+// Route sets up the routes for the AdminController
+func (h *AdminController) Route() *chi.Mux {
+	r := chi.NewRouter()
+
+	r.Post("/messages/redrive", h.RedriveMessages)
+	r.Post("/messages/{id}/redrive", h.RedriveMessage)
+	r.Get("/jobs/{id}", h.GetJob)
+	r.Post("/routing/dry-run", h.RoutingDryRun)
+	return r
+}
+
+// @Summary Redrive message
+// @Description Reset the delivery state of a failed or dead-lettered message and send it again
+// @Tags Admin
+// @Produce json
+// @Param id path int true "Message ID"
+// @Success 202 {object} models.Job "Redrive job"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 409 {string} string "Message is not failed or dead-lettered"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/admin/messages/{id}/redrive [post]
+func (h *AdminController) RedriveMessage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		h.log.Info("invalid message id format")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	filter := models.RedriveFilter{
+		MessageID: &id,
+		Statuses:  []models.MessageStatus{models.StatusFailed, models.StatusDead},
+	}
+
+	ids, err := h.storage.FindRedriveCandidates(h.ctx, filter, 1)
+	if err != nil {
+		h.log.Info("error finding messages to redrive: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(ids) == 0 {
+		http.Error(w, "message is not failed or dead-lettered", http.StatusConflict)
+		return
+	}
+
+	h.startRedrive(w, ids)
+}
+
+// @Summary Redrive messages
+// @Description Reset the delivery state of the failed and dead-lettered messages matching the filter and send them again
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param filter body models.RedriveFilter false "Created time range, statuses (failed, dead) and error substring"
+// @Success 202 {object} models.Job "Redrive job"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/admin/messages/redrive [post]
+func (h *AdminController) RedriveMessages(w http.ResponseWriter, r *http.Request) {
+	var filter models.RedriveFilter
+	if err := json.NewDecoder(r.Body).Decode(&filter); err != nil && !errors.Is(err, io.EOF) {
+		h.log.Info("cannot decode request JSON body: ", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if len(filter.Statuses) == 0 {
+		filter.Statuses = []models.MessageStatus{models.StatusFailed, models.StatusDead}
+	}
+	for _, status := range filter.Statuses {
+		if status != models.StatusFailed && status != models.StatusDead {
+			http.Error(w, fmt.Sprintf("cannot redrive messages with status %q", status), http.StatusBadRequest)
+			return
+		}
+	}
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	ids, err := h.storage.FindRedriveCandidates(h.ctx, filter, maxRedriveBatch)
+	if err != nil {
+		h.log.Info("error finding messages to redrive: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	h.startRedrive(w, ids)
+}
+
+// @Summary Get job
+// @Description Get the progress of a background job
+// @Tags Admin
+// @Produce json
+// @Param id path string true "Job ID"
+// @Success 200 {object} models.Job "Job progress"
+// @Failure 404 {string} string "Not Found"
+// @Router /api/admin/jobs/{id} [get]
+func (h *AdminController) GetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.jobs.Get(chi.URLParam(r, "id"))
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	h.writeJob(w, http.StatusOK, job)
+}
+
+// @Summary Routing dry run
+// @Description Report which routing rule, topic and partition key a sample message would get
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param message body routing.Input true "Sample message"
+// @Success 200 {object} routing.Route "Chosen route"
+// @Failure 400 {string} string "Bad Request"
+// @Router /api/admin/routing/dry-run [post]
+func (h *AdminController) RoutingDryRun(w http.ResponseWriter, r *http.Request) {
+	var in routing.Input
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		h.log.Info("cannot decode request JSON body: ", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.router.Route(in)); err != nil {
+		h.log.Info("error encoding response: ", zap.Error(err))
+	}
+}
+
+// startRedrive creates a redrive job and enqueues one worker pool task per message.
+// Tasks are enqueued in the background so a large job does not hold the request,
+// and the job is updated with the result of every task once it is processed.
+func (h *AdminController) startRedrive(w http.ResponseWriter, ids []int) {
+	job := h.jobs.Create(redriveJob, len(ids))
+
+	go func() {
+		for _, id := range ids {
+			task := workerpool.NewTask(h.ctx, func(ctx context.Context, data interface{}) (interface{}, error) {
+				messageID, ok := data.(int)
+				if !ok { // type assertion failed
+					return nil, nil
+				}
+
+				return nil, h.storage.RedriveMessage(ctx, messageID)
+			}, id)
+			if err := h.pool.AddTask(h.ctx, task); err != nil {
+				h.jobs.Done(job.ID, fmt.Errorf("message %d: %w", id, err))
+				continue
+			}
+
+			go func(messageID int) {
+				res, err := task.Wait(h.ctx)
+				if err == nil {
+					err = res.Err
+				}
+				if err != nil {
+					err = fmt.Errorf("message %d: %w", messageID, err)
+				}
+				h.jobs.Done(job.ID, err)
+			}(id)
+		}
+	}()
+
+	h.log.Info("redrive job started", zap.String("jobID", job.ID), zap.Int("messages", len(ids)))
+	h.writeJob(w, http.StatusAccepted, job)
+}
+
+func (h *AdminController) writeJob(w http.ResponseWriter, status int, job models.Job) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		h.log.Info("error encoding response: ", zap.Error(err))
+	}
+}
@@ -2,21 +2,50 @@ package controllers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/go-chi/chi"
+	"github.com/wurt83ow/gophstream/internal/middleware"
 	"github.com/wurt83ow/gophstream/internal/models"
+	"github.com/wurt83ow/gophstream/internal/storage"
+	"github.com/wurt83ow/gophstream/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
+const (
+	// idempotencyKeyHeader is the request header that makes adding a message idempotent
+	idempotencyKeyHeader = "Idempotency-Key"
+	// maxIdempotencyKeyLength is the longest accepted Idempotency-Key
+	maxIdempotencyKeyLength = 255
+	// defaultIdempotencyTTL is used when the configured TTL cannot be parsed
+	defaultIdempotencyTTL = 24 * time.Hour
+	// defaultMaxBatchSize is used when the configured batch size cannot be parsed
+	defaultMaxBatchSize = 1000
+)
+
 // Storage interface for database operations
 type Storage interface {
-	InsertMessage(context.Context, models.Message) error
-	GetMessages(context.Context, models.Filter, models.Pagination) ([]models.Message, error)
+	InsertMessage(context.Context, models.Message, *models.IdempotencyRecord) (models.Message, *models.IdempotencyRecord, error)
+	InsertMessages(context.Context, []models.Message) ([]models.Message, error)
+	GetMessage(context.Context, int) (models.Message, error)
+	GetMessages(context.Context, models.Filter, models.Sort, models.Pagination) ([]models.Message, error)
+	StreamMessages(context.Context, models.Filter, models.Sort, func(models.Message) error) error
+	GetMessageEvents(ctx context.Context, afterID int64, limit int) ([]models.MessageEvent, error)
+	GetStats(context.Context, models.StatsQuery) (models.Stats, error)
+}
+
+// Feed interface for the live message events
+type Feed interface {
+	Subscribe() (<-chan models.MessageEvent, func())
 }
 
 // Log interface for logging
@@ -28,16 +57,36 @@ type Log interface {
 type BaseController struct {
 	ctx            context.Context
 	storage        Storage
+	feed           Feed
 	defaultEndTime func() string
+	idempotencyTTL time.Duration
+	maxBatchSize   int
 	log            Log
 }
 
 // NewBaseController creates a new BaseController instance
-func NewBaseController(ctx context.Context, storage Storage, defaultEndTime func() string, log Log) *BaseController {
+func NewBaseController(ctx context.Context, storage Storage, feed Feed, defaultEndTime func() string,
+	idempotencyTTL func() string, maxBatchSize func() string, log Log,
+) *BaseController {
+	ttl, err := time.ParseDuration(idempotencyTTL())
+	if err != nil || ttl <= 0 {
+		log.Info("cannot convert option 'IdempotencyTTL': ", zap.String("value", idempotencyTTL()))
+		ttl = defaultIdempotencyTTL
+	}
+
+	batchSize, err := strconv.Atoi(maxBatchSize())
+	if err != nil || batchSize < 1 {
+		log.Info("cannot convert option 'MaxBatchSize': ", zap.String("value", maxBatchSize()))
+		batchSize = defaultMaxBatchSize
+	}
+
 	instance := &BaseController{
 		ctx:            ctx,
 		storage:        storage,
+		feed:           feed,
 		defaultEndTime: defaultEndTime,
+		idempotencyTTL: ttl,
+		maxBatchSize:   batchSize,
 		log:            log,
 	}
 
@@ -49,7 +98,12 @@ func (h *BaseController) Route() *chi.Mux {
 	r := chi.NewRouter()
 
 	r.Post("/api/message", h.AddMessage)
-	r.Get("/api/messages", h.GetProcessedMessages)
+	r.Post("/api/messages:batch", h.AddMessages)
+	r.Get("/api/messages", h.GetMessages)
+	r.Get("/api/messages/{id}", h.GetMessage)
+	r.Get("/api/messages/stream", h.StreamEvents)
+	r.Get("/api/stats", h.GetStats)
+	r.With(middleware.GzipMiddleware).Get("/api/messages/export", h.ExportMessages)
 	return r
 }
 
@@ -59,8 +113,11 @@ func (h *BaseController) Route() *chi.Mux {
 // @Accept json
 // @Produce json
 // @Param message body models.RequestMessage true "Message Info"
-// @Success 200 {string} string "Message added to the database successfully"
+// @Param Idempotency-Key header string false "Key that makes retries of the request return the original response"
+// @Success 201 {object} models.Message "Message added to the database"
+// @Header 201 {string} Location "URL of the message"
 // @Failure 400 {string} string "Bad Request"
+// @Failure 422 {string} string "Idempotency-Key was used with a different request body"
 // @Failure 500 {string} string "Internal Server Error"
 // @Router /api/message [post]
 func (h *BaseController) AddMessage(w http.ResponseWriter, r *http.Request) {
@@ -71,72 +128,214 @@ func (h *BaseController) AddMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	message := models.Message{
-		Content:   msg.Content,
-		CreatedAt: time.Now(),
-		Processed: false,
+	if err := validateMessage(msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	if err := h.storage.InsertMessage(h.ctx, message); err != nil {
+	var idem *models.IdempotencyRecord
+	if key := r.Header.Get(idempotencyKeyHeader); key != "" {
+		if len(key) > maxIdempotencyKeyLength {
+			http.Error(w, fmt.Sprintf("%s must not be longer than %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength), http.StatusBadRequest)
+			return
+		}
+
+		hash, err := requestHash(msg)
+		if err != nil {
+			h.log.Info("cannot hash request: ", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		idem = &models.IdempotencyRecord{
+			Key:         key,
+			RequestHash: hash,
+			StatusCode:  http.StatusCreated,
+			ExpiresAt:   time.Now().Add(h.idempotencyTTL),
+		}
+	}
+
+	// the message keeps the trace of the request, which is continued when it is published
+	ctx := trace.ContextWithSpan(h.ctx, trace.SpanFromContext(r.Context()))
+	created, stored, err := h.storage.InsertMessage(ctx, newMessage(msg, tracing.TraceParent(ctx)), idem)
+	if err != nil {
 		h.log.Info("error inserting message to storage: ", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if stored != nil {
+		h.replay(w, idem, stored)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", messageLocation(created.ID))
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(created); err != nil {
+		h.log.Info("error encoding response: ", zap.Error(err))
+	}
+	h.log.Info("Message added to the database successfully", zap.Int("messageID", created.ID))
+}
+
+// @Summary Get message
+// @Description Get a message and its delivery state
+// @Tags Messages
+// @Produce json
+// @Param id path int true "Message ID"
+// @Success 200 {object} models.Message "Message"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/messages/{id} [get]
+func (h *BaseController) GetMessage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		h.log.Info("invalid message id format")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 
-	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write([]byte("Message added to the database successfully")); err != nil {
+	message, err := h.storage.GetMessage(h.ctx, id)
+	if errors.Is(err, storage.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.log.Info("error getting message from storage: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(message); err != nil {
+		h.log.Info("error encoding response: ", zap.Error(err))
+	}
+}
+
+// messageLocation returns the URL of a message
+func messageLocation(id int) string {
+	return "/api/messages/" + strconv.Itoa(id)
+}
+
+// validateMessage checks a message received from the client
+func validateMessage(msg models.RequestMessage) error {
+	for name := range msg.Headers {
+		if name == "" {
+			return errors.New("header names must not be empty")
+		}
+	}
+
+	return nil
+}
+
+// newMessage creates a queued message from the client request
+// and the trace context of the request
+func newMessage(msg models.RequestMessage, traceParent string) models.Message {
+	return models.Message{
+		Content:     msg.Content,
+		Topic:       msg.Topic,
+		Type:        msg.Type,
+		Key:         msg.Key,
+		Headers:     msg.Headers,
+		CreatedAt:   time.Now(),
+		Status:      models.StatusQueued,
+		TraceParent: traceParent,
+	}
+}
+
+// replay answers a request whose Idempotency-Key was already used
+// with the response stored for the first request. The key is reserved and
+// completed in the transaction that inserts the message, so a stored record
+// always holds the response.
+func (h *BaseController) replay(w http.ResponseWriter, idem *models.IdempotencyRecord, stored *models.IdempotencyRecord) {
+	if stored.RequestHash != idem.RequestHash {
+		http.Error(w, idempotencyKeyHeader+" was used with a different request body", http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", messageLocation(stored.MessageID))
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(stored.StatusCode)
+	if _, err := w.Write(stored.Response); err != nil {
 		h.log.Info("error writing response: ", zap.Error(err))
 	}
-	h.log.Info("Message added to the database successfully")
+	h.log.Info("Idempotent request replayed", zap.String("key", stored.Key), zap.Int("messageID", stored.MessageID))
+}
+
+// requestHash returns the SHA-256 of the decoded request, so replays
+// that only differ in formatting or field order are recognized
+func requestHash(msg models.RequestMessage) (string, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
 }
 
-// @Summary Get processed messages
-// @Description Get processed messages from the database
+// @Summary Get messages
+// @Description Get the messages matching the filter from the database
 // @Tags Messages
 // @Accept json
 // @Produce json
-// @Param limit query int false "Limit"
+// @Param status query string false "Comma separated delivery statuses (queued, in_flight, sent, failed, dead)"
+// @Param created_from query string false "Created at or after (RFC 3339)"
+// @Param created_to query string false "Created before (RFC 3339)"
+// @Param min_id query int false "Lowest message ID"
+// @Param max_id query int false "Highest message ID"
+// @Param q query string false "Substring of the content, case insensitive"
+// @Param search query string false "Full-text search in the content"
+// @Param topic query string false "Requested topic"
+// @Param type query string false "Message type"
+// @Param header query []string false "Header match as name:value, repeatable"
+// @Param sort query string false "Sort field (id, created_at, sent_at, status, attempts), defaults to id"
+// @Param order query string false "Sort order (asc, desc), defaults to asc"
+// @Param limit query int false "Limit, defaults to 100"
 // @Param offset query int false "Offset"
-// @Success 200 {array} models.Message "List of processed messages"
+// @Param cursor query string false "Cursor returned as next_cursor, empty for the first page; pages by (created_at, id)"
+// @Success 200 {array} models.Message "List of messages"
+// @Success 200 {object} models.MessagePage "Page of messages when a cursor is given"
 // @Failure 400 {string} string "Bad Request"
 // @Failure 500 {string} string "Internal Server Error"
 // @Router /api/messages [get]
-func (h *BaseController) GetProcessedMessages(w http.ResponseWriter, r *http.Request) {
-	var pagination models.Pagination
-
-	if v := r.URL.Query().Get("limit"); v != "" {
-		val, err := strconv.Atoi(v)
-		if err != nil {
-			h.log.Info("invalid limit format")
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		pagination.Limit = val
-	}
-	if v := r.URL.Query().Get("offset"); v != "" {
-		val, err := strconv.Atoi(v)
-		if err != nil {
-			h.log.Info("invalid offset format")
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		pagination.Offset = val
+func (h *BaseController) GetMessages(w http.ResponseWriter, r *http.Request) {
+	filter, sort, pagination, err := parseMessageQuery(r.URL.Query())
+	if err != nil {
+		h.log.Info("invalid messages query", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	processed := true
-	filter := models.Filter{
-		Processed: &processed,
+	limit := pagination.Limit
+	if pagination.Keyset {
+		// one more message tells whether there is a next page
+		pagination.Limit++
 	}
 
-	messages, err := h.storage.GetMessages(h.ctx, filter, pagination)
+	messages, err := h.storage.GetMessages(h.ctx, filter, sort, pagination)
 	if err != nil {
-		h.log.Info("error getting processed messages from storage: ", zap.Error(err))
+		h.log.Info("error getting messages from storage: ", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if messages == nil {
+		messages = []models.Message{}
+	}
+
+	var response any = messages
+	if pagination.Keyset {
+		page := models.MessagePage{Messages: messages}
+		if len(messages) > limit {
+			page.Messages = messages[:limit]
+			page.NextCursor = encodeCursor(page.Messages[limit-1], sort.Desc)
+		}
+		response = page
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(messages); err != nil {
+	if err := json.NewEncoder(w).Encode(response); err != nil {
 		h.log.Info("error encoding response: ", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
 	}
@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/wurt83ow/gophstream/internal/models"
+	"go.uber.org/zap/zapcore"
+)
+
+type nopLog struct{}
+
+func (nopLog) Info(string, ...zapcore.Field) {}
+
+func option(v string) func() string {
+	return func() string { return v }
+}
+
+// fakeStorage keeps the messages and idempotency keys in memory,
+// like the database an insert and its key are stored together
+type fakeStorage struct {
+	Storage
+
+	mx       sync.Mutex
+	messages []models.Message
+	keys     map[string]models.IdempotencyRecord
+	events   []models.MessageEvent
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{keys: make(map[string]models.IdempotencyRecord)}
+}
+
+func (s *fakeStorage) InsertMessage(_ context.Context, message models.Message,
+	idem *models.IdempotencyRecord,
+) (models.Message, *models.IdempotencyRecord, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if idem != nil {
+		if stored, ok := s.keys[idem.Key]; ok {
+			return models.Message{}, &stored, nil
+		}
+	}
+
+	message.ID = len(s.messages) + 1
+	s.messages = append(s.messages, message)
+
+	if idem != nil {
+		response, err := json.Marshal(message)
+		if err != nil {
+			return models.Message{}, nil, err
+		}
+		record := *idem
+		record.MessageID = message.ID
+		record.Response = response
+		s.keys[idem.Key] = record
+	}
+
+	return message, nil, nil
+}
+
+func (s *fakeStorage) InsertMessages(_ context.Context, messages []models.Message) ([]models.Message, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	created := make([]models.Message, len(messages))
+	for i, message := range messages {
+		message.ID = len(s.messages) + 1
+		s.messages = append(s.messages, message)
+		created[i] = message
+	}
+
+	return created, nil
+}
+
+func (s *fakeStorage) StreamMessages(_ context.Context, _ models.Filter, _ models.Sort,
+	fn func(models.Message) error,
+) error {
+	s.mx.Lock()
+	messages := append([]models.Message(nil), s.messages...)
+	s.mx.Unlock()
+
+	for _, message := range messages {
+		if err := fn(message); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *fakeStorage) GetMessageEvents(_ context.Context, afterID int64, limit int) ([]models.MessageEvent, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	var events []models.MessageEvent
+	for _, event := range s.events {
+		if event.ID > afterID && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+
+	return events, nil
+}
+
+func newTestController(storage Storage) *BaseController {
+	return NewBaseController(context.Background(), storage, nil, option(""), option("1h"), option("3"), nopLog{})
+}
+
+func TestAddMessageIdempotencyKey(t *testing.T) {
+	const first = `{"content":"hello","topic":"orders"}`
+
+	tests := []struct {
+		name         string
+		body         string
+		wantStatus   int
+		wantReplayed bool
+		wantMessages int
+	}{
+		{
+			name:         "same body",
+			body:         `{"topic":"orders","content":"hello"}`,
+			wantStatus:   http.StatusCreated,
+			wantReplayed: true,
+			wantMessages: 1,
+		},
+		{
+			name:         "different body",
+			body:         `{"content":"bye","topic":"orders"}`,
+			wantStatus:   http.StatusUnprocessableEntity,
+			wantMessages: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			h := newTestController(storage)
+
+			post := func(body string) *httptest.ResponseRecorder {
+				r := httptest.NewRequest(http.MethodPost, "/api/message", strings.NewReader(body))
+				r.Header.Set(idempotencyKeyHeader, "key-1")
+				w := httptest.NewRecorder()
+				h.AddMessage(w, r)
+				return w
+			}
+
+			original := post(first)
+			if original.Code != http.StatusCreated {
+				t.Fatalf("first request status = %d, want %d", original.Code, http.StatusCreated)
+			}
+
+			replay := post(tt.body)
+			if replay.Code != tt.wantStatus {
+				t.Fatalf("replayed request status = %d, want %d: %s", replay.Code, tt.wantStatus, replay.Body)
+			}
+			if replayed := replay.Header().Get("Idempotent-Replayed") == "true"; replayed != tt.wantReplayed {
+				t.Errorf("Idempotent-Replayed = %v, want %v", replayed, tt.wantReplayed)
+			}
+			if tt.wantReplayed {
+				if strings.TrimSpace(replay.Body.String()) != strings.TrimSpace(original.Body.String()) {
+					t.Errorf("replayed body = %s, want %s", replay.Body, original.Body)
+				}
+				if replay.Header().Get("Location") != original.Header().Get("Location") {
+					t.Errorf("replayed Location = %q, want %q", replay.Header().Get("Location"), original.Header().Get("Location"))
+				}
+			}
+			if len(storage.messages) != tt.wantMessages {
+				t.Errorf("stored %d messages, want %d", len(storage.messages), tt.wantMessages)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/wurt83ow/gophstream/internal/models"
+	"github.com/wurt83ow/gophstream/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// maxBatchLineSize is the longest accepted line of an NDJSON batch
+const maxBatchLineSize = 1 << 20
+
+// errBatchTooLarge is returned when a batch holds more items than allowed
+var errBatchTooLarge = errors.New("batch too large")
+
+// batchItem is an item of a batch request, err is set if it could not be decoded
+type batchItem struct {
+	msg models.RequestMessage
+	err error
+}
+
+// @Summary Add messages
+// @Description Add a batch of messages given as a JSON array or as NDJSON, one message per line.
+// @Description Every item is validated on its own; the valid ones are inserted together.
+// @Tags Messages
+// @Accept json
+// @Accept x-ndjson
+// @Produce json
+// @Param messages body []models.RequestMessage true "Messages"
+// @Success 200 {object} models.BatchResult "All messages added"
+// @Success 207 {object} models.BatchResult "Some messages rejected"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 413 {string} string "Batch too large"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/messages:batch [post]
+func (h *BaseController) AddMessages(w http.ResponseWriter, r *http.Request) {
+	items, err := h.decodeBatch(r.Body)
+	if errors.Is(err, errBatchTooLarge) {
+		http.Error(w, fmt.Sprintf("a batch must not hold more than %d messages", h.maxBatchSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		h.log.Info("cannot decode request body: ", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(items) == 0 {
+		http.Error(w, "the batch is empty", http.StatusBadRequest)
+		return
+	}
+
+	ctx := trace.ContextWithSpan(h.ctx, trace.SpanFromContext(r.Context()))
+	result := models.BatchResult{Results: make([]models.BatchItemResult, len(items))}
+	var messages []models.Message
+	var indexes []int
+
+	for i, item := range items {
+		result.Results[i].Index = i
+		if item.err == nil {
+			item.err = validateMessage(item.msg)
+		}
+		if item.err != nil {
+			result.Results[i].Error = item.err.Error()
+			result.Failed++
+			continue
+		}
+
+		messages = append(messages, newMessage(item.msg, tracing.TraceParent(ctx)))
+		indexes = append(indexes, i)
+	}
+
+	if len(messages) != 0 {
+		created, err := h.storage.InsertMessages(ctx, messages)
+		if err != nil {
+			h.log.Info("error inserting messages to storage: ", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		for i, message := range created {
+			result.Results[indexes[i]].ID = message.ID
+		}
+		result.Succeeded = len(created)
+	}
+
+	status := http.StatusOK
+	if result.Failed != 0 {
+		status = http.StatusMultiStatus
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		h.log.Info("error encoding response: ", zap.Error(err))
+	}
+	h.log.Info("Messages added to the database", zap.Int("succeeded", result.Succeeded), zap.Int("failed", result.Failed))
+}
+
+// decodeBatch reads a batch given as a JSON array or as NDJSON.
+// Items that are not valid messages are reported on their own, except for
+// syntax errors in an array, after which the following items cannot be found.
+func (h *BaseController) decodeBatch(body io.Reader) ([]batchItem, error) {
+	br := bufio.NewReader(body)
+
+	for {
+		b, err := br.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if b == ' ' || b == '\t' || b == '\r' || b == '\n' {
+			continue
+		}
+		if err := br.UnreadByte(); err != nil {
+			return nil, err
+		}
+
+		if b == '[' {
+			return h.decodeArray(br)
+		}
+		return h.decodeLines(br)
+	}
+}
+
+// decodeArray reads the items of a JSON array one at a time
+func (h *BaseController) decodeArray(body io.Reader) ([]batchItem, error) {
+	dec := json.NewDecoder(body)
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+
+	var items []batchItem
+	for dec.More() {
+		if len(items) == h.maxBatchSize {
+			return nil, errBatchTooLarge
+		}
+
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, fmt.Errorf("item %d: %w", len(items), err)
+		}
+		items = append(items, decodeItem(raw))
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// decodeLines reads one item per line, empty lines are skipped
+func (h *BaseController) decodeLines(body io.Reader) ([]batchItem, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBatchLineSize)
+
+	var items []batchItem
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if len(items) == h.maxBatchSize {
+			return nil, errBatchTooLarge
+		}
+
+		items = append(items, decodeItem(line))
+	}
+
+	return items, scanner.Err()
+}
+
+// decodeItem decodes a single message of a batch
+func decodeItem(data []byte) batchItem {
+	var item batchItem
+	if err := json.Unmarshal(data, &item.msg); err != nil {
+		item.err = fmt.Errorf("invalid message: %w", err)
+	}
+
+	return item
+}
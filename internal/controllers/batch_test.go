@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wurt83ow/gophstream/internal/models"
+)
+
+func TestAddMessages(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		// want holds the ID of every item, 0 for the rejected ones
+		want []int
+	}{
+		{
+			name:       "array",
+			body:       `[{"content":"a"}, {"content":"b"}]`,
+			wantStatus: http.StatusOK,
+			want:       []int{1, 2},
+		},
+		{
+			name:       "ndjson",
+			body:       "{\"content\":\"a\"}\n\n{\"content\":\"b\"}\r\n",
+			wantStatus: http.StatusOK,
+			want:       []int{1, 2},
+		},
+		{
+			name:       "array with an invalid item",
+			body:       `[{"content":"a"}, {"content":1}, {"content":"c"}]`,
+			wantStatus: http.StatusMultiStatus,
+			want:       []int{1, 0, 2},
+		},
+		{
+			name:       "ndjson with an invalid line",
+			body:       "{\"content\":\"a\"}\nnot json\n{\"content\":\"c\"}",
+			wantStatus: http.StatusMultiStatus,
+			want:       []int{1, 0, 2},
+		},
+		{
+			name:       "empty header name",
+			body:       `[{"content":"a","headers":{"":"x"}}, {"content":"b"}]`,
+			wantStatus: http.StatusMultiStatus,
+			want:       []int{0, 1},
+		},
+		{
+			name:       "all rejected",
+			body:       `[1, "a"]`,
+			wantStatus: http.StatusMultiStatus,
+			want:       []int{0, 0},
+		},
+		{name: "empty", body: " \n", wantStatus: http.StatusBadRequest},
+		{name: "empty array", body: `[]`, wantStatus: http.StatusBadRequest},
+		{name: "broken array", body: `[{"content":"a"}, {`, wantStatus: http.StatusBadRequest},
+		{
+			name:       "too large",
+			body:       `[{"content":"a"}, {"content":"b"}, {"content":"c"}, {"content":"d"}]`,
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "too many lines",
+			body:       "{}\n{}\n{}\n{}",
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			h := newTestController(storage)
+
+			r := httptest.NewRequest(http.MethodPost, "/api/messages:batch", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			h.AddMessages(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.want == nil {
+				if len(storage.messages) != 0 {
+					t.Errorf("stored %d messages, want none", len(storage.messages))
+				}
+				return
+			}
+
+			var result models.BatchResult
+			if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+				t.Fatalf("cannot decode response %s: %v", w.Body, err)
+			}
+			if len(result.Results) != len(tt.want) {
+				t.Fatalf("got %d results, want %d", len(result.Results), len(tt.want))
+			}
+
+			succeeded := 0
+			for i, item := range result.Results {
+				if item.Index != i {
+					t.Errorf("result %d has index %d", i, item.Index)
+				}
+				if item.ID != tt.want[i] {
+					t.Errorf("result %d ID = %d, want %d", i, item.ID, tt.want[i])
+				}
+				if (item.Error == "") != (tt.want[i] != 0) {
+					t.Errorf("result %d error = %q, want an error only for rejected items", i, item.Error)
+				}
+				if tt.want[i] != 0 {
+					succeeded++
+				}
+			}
+			if result.Succeeded != succeeded || result.Failed != len(tt.want)-succeeded {
+				t.Errorf("succeeded, failed = %d, %d, want %d, %d", result.Succeeded, result.Failed, succeeded, len(tt.want)-succeeded)
+			}
+			if len(storage.messages) != succeeded {
+				t.Errorf("stored %d messages, want %d", len(storage.messages), succeeded)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/wurt83ow/gophstream/internal/models"
+	"go.uber.org/zap"
+)
+
+// Consumer interface for reading Kafka consumer statistics
+type Consumer interface {
+	Stats() models.ConsumerStats
+}
+
+// ConsumerController struct for reporting end-to-end delivery
+type ConsumerController struct {
+	ctx      context.Context
+	consumer Consumer
+	log      Log
+}
+
+// NewConsumerController creates a new ConsumerController instance
+func NewConsumerController(ctx context.Context, consumer Consumer, log Log) *ConsumerController {
+	return &ConsumerController{
+		ctx:      ctx,
+		consumer: consumer,
+		log:      log,
+	}
+}
+
+// Route sets up the routes for the ConsumerController
+func (h *ConsumerController) Route() *chi.Mux {
+	r := chi.NewRouter()
+
+	r.Get("/stats", h.GetConsumerStats)
+	return r
+}
+
+// @Summary Get consumer statistics
+// @Description Get the lag and receipt counters of the Kafka consumer
+// @Tags Consumer
+// @Produce json
+// @Success 200 {object} models.ConsumerStats "Consumer statistics"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/consumer/stats [get]
+func (h *ConsumerController) GetConsumerStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.consumer.Stats()); err != nil {
+		h.log.Info("error encoding response: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
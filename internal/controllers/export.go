@@ -0,0 +1,209 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/wurt83ow/gophstream/internal/models"
+	"go.uber.org/zap"
+)
+
+// exportFlushRows is the number of rows written between flushes of the response
+const exportFlushRows = 1000
+
+// exportHeader is the header row of a CSV export
+var exportHeader = []string{
+	"id", "content", "topic", "type", "key", "headers", "created_at", "status",
+	"attempts", "last_error", "error_class", "sent_at", "kafka_partition", "kafka_offset",
+}
+
+// rowWriter writes exported messages in one format
+type rowWriter interface {
+	Write(models.Message) error
+	Flush() error
+}
+
+// @Summary Export messages
+// @Description Stream all messages matching the filter as NDJSON or CSV.
+// @Description Takes the same filter and sort parameters as the messages listing; the response is gzip compressed if the client accepts it.
+// @Tags Messages
+// @Produce application/x-ndjson
+// @Produce text/csv
+// @Param format query string false "Export format (ndjson, csv), defaults to ndjson"
+// @Success 200 {string} string "Exported messages"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/messages/export [get]
+func (h *BaseController) ExportMessages(w http.ResponseWriter, r *http.Request) {
+	filter, sort, _, err := parseMessageQuery(r.URL.Query())
+	if err != nil {
+		h.log.Info("invalid messages query", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var rw rowWriter
+	var contentType, ext string
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "ndjson":
+		rw, contentType, ext = &ndjsonWriter{enc: json.NewEncoder(w)}, "application/x-ndjson", "ndjson"
+	case "csv":
+		rw, contentType, ext = newCSVWriter(w), "text/csv", "csv"
+	default:
+		http.Error(w, fmt.Sprintf("format must be ndjson or csv, got %q", format), http.StatusBadRequest)
+		return
+	}
+
+	// the status is sent with the first row, so a query that fails right away is still reported
+	started := false
+	start := func() error {
+		if started {
+			return nil
+		}
+		started = true
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="messages.%s"`, ext))
+		w.WriteHeader(http.StatusOK)
+		if c, ok := rw.(*csvWriter); ok {
+			return c.header()
+		}
+		return nil
+	}
+
+	// an export may take longer than the write timeout of the server
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		h.log.Info("cannot extend write deadline for export: ", zap.Error(err))
+	}
+
+	flusher, _ := w.(http.Flusher)
+	rows := 0
+
+	err = h.storage.StreamMessages(r.Context(), filter, sort, func(message models.Message) error {
+		if err := start(); err != nil {
+			return err
+		}
+		if err := rw.Write(message); err != nil {
+			return err
+		}
+
+		rows++
+		if rows%exportFlushRows == 0 {
+			if err := rw.Flush(); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return nil
+	})
+	if err != nil && !started {
+		h.log.Info("error exporting messages: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		// the response is already on its way, the client sees a truncated export
+		h.log.Info("export interrupted: ", zap.Error(err), zap.Int("rows", rows))
+		return
+	}
+
+	if err := start(); err != nil {
+		h.log.Info("error writing response: ", zap.Error(err))
+		return
+	}
+	if err := rw.Flush(); err != nil {
+		h.log.Info("error writing response: ", zap.Error(err))
+		return
+	}
+	h.log.Info("Messages exported", zap.String("format", ext), zap.Int("rows", rows))
+}
+
+// ndjsonWriter writes one JSON message per line
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonWriter) Write(message models.Message) error {
+	return n.enc.Encode(message)
+}
+
+func (n *ndjsonWriter) Flush() error {
+	return nil
+}
+
+// csvWriter writes one message per CSV record
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCSVWriter(w io.Writer) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+func (c *csvWriter) header() error {
+	return c.w.Write(exportHeader)
+}
+
+func (c *csvWriter) Write(m models.Message) error {
+	var headers string
+	if len(m.Headers) != 0 {
+		data, err := json.Marshal(m.Headers)
+		if err != nil {
+			return err
+		}
+		headers = string(data)
+	}
+
+	return c.w.Write([]string{
+		strconv.Itoa(m.ID),
+		m.Content,
+		m.Topic,
+		m.Type,
+		m.Key,
+		headers,
+		m.CreatedAt.Format(time.RFC3339Nano),
+		string(m.Status),
+		strconv.Itoa(m.Attempts),
+		m.LastError,
+		string(m.ErrorClass),
+		formatTime(m.SentAt),
+		formatInt(m.KafkaPartition),
+		formatInt64(m.KafkaOffset),
+	})
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+
+	return t.Format(time.RFC3339Nano)
+}
+
+func formatInt(v *int) string {
+	if v == nil {
+		return ""
+	}
+
+	return strconv.Itoa(*v)
+}
+
+func formatInt64(v *int64) string {
+	if v == nil {
+		return ""
+	}
+
+	return strconv.FormatInt(*v, 10)
+}
@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wurt83ow/gophstream/internal/models"
+)
+
+func TestExportMessagesEscaping(t *testing.T) {
+	createdAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		content string
+		key     string
+		headers map[string]string
+	}{
+		{name: "plain", content: "hello"},
+		{name: "comma", content: "a,b,c", key: "k,1"},
+		{name: "quotes", content: `say "hi"`, key: `"quoted"`},
+		{name: "newlines", content: "line 1\nline 2\r\nline 3"},
+		{name: "leading space and unicode", content: "  привет 👋\t"},
+		{name: "json in content", content: `{"a":[1,2],"b":"c\"d"}`},
+		{name: "headers with quotes and commas", content: "x", headers: map[string]string{"a,b": `c"d`, "e": "f\ng"}},
+	}
+
+	for _, tt := range tests {
+		message := models.Message{
+			ID:        1,
+			Content:   tt.content,
+			Key:       tt.key,
+			Headers:   tt.headers,
+			CreatedAt: createdAt,
+			Status:    models.StatusQueued,
+		}
+
+		t.Run(tt.name+"/csv", func(t *testing.T) {
+			w := export(t, message, "csv")
+
+			records, err := csv.NewReader(w.Body).ReadAll()
+			if err != nil {
+				t.Fatalf("cannot read CSV export %q: %v", w.Body, err)
+			}
+			if len(records) != 2 || !reflect.DeepEqual(records[0], exportHeader) {
+				t.Fatalf("CSV export = %q, want the header and one record", records)
+			}
+
+			// csv.Reader drops the carriage return of a line break inside a quoted field
+			record := records[1]
+			content := strings.ReplaceAll(tt.content, "\r\n", "\n")
+			if record[1] != content || record[4] != tt.key {
+				t.Errorf("content, key = %q, %q, want %q, %q", record[1], record[4], content, tt.key)
+			}
+			var headers map[string]string
+			if record[5] != "" {
+				if err := json.Unmarshal([]byte(record[5]), &headers); err != nil {
+					t.Fatalf("cannot decode headers %q: %v", record[5], err)
+				}
+			}
+			if !reflect.DeepEqual(headers, tt.headers) {
+				t.Errorf("headers = %v, want %v", headers, tt.headers)
+			}
+		})
+
+		t.Run(tt.name+"/ndjson", func(t *testing.T) {
+			w := export(t, message, "ndjson")
+
+			lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+			if len(lines) != 1 {
+				t.Fatalf("NDJSON export has %d lines, want 1: %q", len(lines), w.Body)
+			}
+
+			var got models.Message
+			dec := json.NewDecoder(strings.NewReader(lines[0]))
+			if err := dec.Decode(&got); err != nil {
+				t.Fatalf("cannot decode NDJSON line %q: %v", lines[0], err)
+			}
+			if _, err := dec.Token(); err != io.EOF {
+				t.Errorf("NDJSON line %q holds more than one value", lines[0])
+			}
+			if got.Content != tt.content || got.Key != tt.key || !reflect.DeepEqual(got.Headers, tt.headers) {
+				t.Errorf("exported %+v, want content %q, key %q, headers %v", got, tt.content, tt.key, tt.headers)
+			}
+		})
+	}
+}
+
+// export exports a single stored message in the given format
+func export(t *testing.T, message models.Message, format string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	storage := newFakeStorage()
+	storage.messages = []models.Message{message}
+	h := newTestController(storage)
+
+	r := httptest.NewRequest(http.MethodGet, "/api/messages/export?format="+format, nil)
+	w := httptest.NewRecorder()
+	h.ExportMessages(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	return w
+}
@@ -3,47 +3,136 @@ package controllers
 import (
 	"context"
 	"encoding/json"
+	"strconv"
+	"time"
 
 	"github.com/wurt83ow/gophstream/internal/models"
+	"github.com/wurt83ow/gophstream/internal/routing"
+	"github.com/wurt83ow/gophstream/internal/tracing"
 	"go.uber.org/zap"
 )
 
 // KafkaProducer interface for Kafka operations
 type KafkaProducer interface {
-	SendMessage(topic string, message []byte) error
+	SendMessage(ctx context.Context, message models.OutgoingMessage) error
+}
+
+// Router interface for choosing the topic and partition key of a message
+type Router interface {
+	Route(routing.Input) routing.Route
 }
 
 type ExtController struct {
-	ctx     context.Context
-	storage Storage
-	kafka   KafkaProducer
-	log     Log
+	ctx             context.Context
+	storage         Storage
+	kafka           KafkaProducer
+	router          Router
+	deadLetterTopic func() string
+	log             Log
 }
 
-func NewExtController(ctx context.Context, storage Storage, kafka KafkaProducer, log Log) *ExtController {
+func NewExtController(ctx context.Context, storage Storage, kafka KafkaProducer,
+	router Router, deadLetterTopic func() string, log Log,
+) *ExtController {
 	return &ExtController{
-		ctx:     ctx,
-		storage: storage,
-		kafka:   kafka,
-		log:     log,
+		ctx:             ctx,
+		storage:         storage,
+		kafka:           kafka,
+		router:          router,
+		deadLetterTopic: deadLetterTopic,
+		log:             log,
+	}
+}
+
+// OutboxMessage builds the Kafka message for the payload of an outbox record,
+// addressed to the topic chosen by the routing rules
+func (c *ExtController) OutboxMessage(record models.OutboxRecord) (models.OutgoingMessage, error) {
+	var message models.Message
+	if err := json.Unmarshal(record.Payload, &message); err != nil {
+		c.log.Info("error unmarshaling outbox payload: ", zap.Error(err), zap.Int64("outboxID", record.ID))
+		return models.OutgoingMessage{}, err
+	}
+
+	route := c.router.Route(routing.Input{
+		ID:      message.ID,
+		Type:    message.Type,
+		Topic:   message.Topic,
+		Content: message.Content,
+		Headers: message.Headers,
+	})
+
+	// the key given with the message takes precedence over the key of the rule
+	key := route.Key
+	if message.Key != "" {
+		key = message.Key
+	}
+
+	// the message ID header identifies the record when the topic is read back
+	headers := make(map[string]string, len(message.Headers)+1)
+	for name, value := range message.Headers {
+		headers[name] = value
+	}
+	headers[models.HeaderMessageID] = strconv.Itoa(record.MessageID)
+	if message.TraceParent != "" {
+		headers[tracing.TraceParentHeader] = message.TraceParent
 	}
+
+	out := models.OutgoingMessage{
+		Topic:   route.Topic,
+		Headers: headers,
+		Value:   record.Payload,
+	}
+	if key != "" {
+		out.Key = []byte(key)
+	}
+
+	c.log.Info("Message routed", zap.Int("messageID", record.MessageID),
+		zap.String("topic", route.Topic), zap.String("rule", route.Rule))
+	return out, nil
 }
 
-// SendMessageToKafka sends a message to Kafka and returns the ID of the sent message
-func (c *ExtController) SendMessageToKafka(message models.Message) (int, error) {
-	// Marshal the message to JSON
-	messageData, err := json.Marshal(message)
+// PublishDeadLetter sends an outbox record that will not be retried to the
+// dead-letter topic together with the reason of the failure
+func (c *ExtController) PublishDeadLetter(ctx context.Context, record models.OutboxRecord, failure models.DeliveryFailure) error {
+	letter := models.DeadLetter{
+		OutboxID:   record.ID,
+		MessageID:  record.MessageID,
+		Attempts:   record.Attempts,
+		Error:      failure.Error,
+		ErrorClass: failure.Class,
+		FailedAt:   time.Now(),
+		Payload:    record.Payload,
+	}
+
+	// Marshal the dead letter to JSON
+	letterData, err := json.Marshal(letter)
 	if err != nil {
-		c.log.Info("error marshaling message: ", zap.Error(err))
-		return 0, err
+		c.log.Info("error marshaling dead letter: ", zap.Error(err))
+		return err
 	}
 
-	// Send the message to Kafka
-	if err := c.kafka.SendMessage("message_topic", messageData); err != nil {
-		c.log.Info("error sending message to Kafka: ", zap.Error(err))
-		return 0, err
+	topic := c.deadLetterTopic()
+	var message models.Message
+	if err := json.Unmarshal(record.Payload, &message); err != nil {
+		c.log.Info("error unmarshaling outbox payload: ", zap.Error(err), zap.Int64("outboxID", record.ID))
+	}
+
+	// publishing the dead letter continues the trace of the message
+	if !tracing.HasSpan(ctx) {
+		ctx = tracing.ContextWithTraceParent(ctx, message.TraceParent)
+	}
+
+	// the dead letter keeps the headers of the message so it can still be traced
+	out := models.OutgoingMessage{
+		Topic:   topic,
+		Headers: message.Headers,
+		Value:   letterData,
+	}
+	if err := c.kafka.SendMessage(ctx, out); err != nil {
+		c.log.Info("error sending message to dead-letter topic: ", zap.Error(err), zap.Int("messageID", record.MessageID))
+		return err
 	}
 
-	c.log.Info("Message sent to Kafka successfully", zap.Int("messageID", message.ID))
-	return message.ID, nil
+	c.log.Info("Message sent to dead-letter topic", zap.Int("messageID", record.MessageID), zap.String("topic", topic))
+	return nil
 }
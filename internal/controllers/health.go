@@ -0,0 +1,211 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/wurt83ow/gophstream/internal/models"
+	"go.uber.org/zap"
+)
+
+const (
+	healthOK          = "ok"
+	healthUnavailable = "unavailable"
+
+	// readyTimeout bounds the time all readiness checks may take together
+	readyTimeout = 2 * time.Second
+	// maxPoolSaturation is the share of the worker pool queue that may be filled while ready
+	maxPoolSaturation = 0.9
+	// relayStaleTicks is how many tick intervals the relay may go without a successful tick
+	relayStaleTicks = 5
+	// minRelayStale is the shortest time the relay may go without a successful tick
+	minRelayStale = 30 * time.Second
+)
+
+// HealthStorage interface for checking the database
+type HealthStorage interface {
+	Ping(context.Context) error
+	SchemaVersion(context.Context) (models.SchemaVersion, error)
+}
+
+// HealthKafka interface for checking the brokers
+type HealthKafka interface {
+	Ping(context.Context) error
+}
+
+// HealthPool interface for checking the saturation of the worker pool
+type HealthPool interface {
+	QueueDepth() int
+	QueueCapacity() int
+}
+
+// Relay interface for checking that the outbox relay loop is running
+type Relay interface {
+	LastTick() time.Time
+	TickInterval() time.Duration
+}
+
+// HealthController struct for handling liveness and readiness probes
+type HealthController struct {
+	ctx     context.Context
+	storage HealthStorage
+	kafka   HealthKafka
+	pool    HealthPool
+	relay   Relay
+	log     Log
+}
+
+// NewHealthController creates a new HealthController instance
+func NewHealthController(ctx context.Context, storage HealthStorage, kafka HealthKafka, pool HealthPool,
+	relay Relay, log Log,
+) *HealthController {
+	return &HealthController{
+		ctx:     ctx,
+		storage: storage,
+		kafka:   kafka,
+		pool:    pool,
+		relay:   relay,
+		log:     log,
+	}
+}
+
+// @Summary Liveness probe
+// @Description Report that the process is running. Dependencies are not checked.
+// @Tags Health
+// @Produce json
+// @Success 200 {object} models.Health "Alive"
+// @Router /healthz [get]
+func (h *HealthController) Liveness(w http.ResponseWriter, r *http.Request) {
+	h.respond(w, http.StatusOK, models.Health{Status: healthOK})
+}
+
+// @Summary Readiness probe
+// @Description Check Postgres, the migration version, the Kafka brokers, the worker pool and the outbox relay.
+// @Description Every component is reported with its status and the reason it is unavailable.
+// @Tags Health
+// @Produce json
+// @Success 200 {object} models.Health "Ready"
+// @Failure 503 {object} models.Health "Not ready"
+// @Router /readyz [get]
+func (h *HealthController) Readiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	checks := map[string]func(context.Context) models.ComponentHealth{
+		"postgres":   h.checkPostgres,
+		"migrations": h.checkMigrations,
+		"kafka":      h.checkKafka,
+		"workerpool": h.checkPool,
+		"relay":      h.checkRelay,
+	}
+
+	health := models.Health{Status: healthOK, Components: make(map[string]models.ComponentHealth, len(checks))}
+
+	var mx sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) models.ComponentHealth) {
+			defer wg.Done()
+			res := check(ctx)
+
+			mx.Lock()
+			defer mx.Unlock()
+			health.Components[name] = res
+			if res.Status != healthOK {
+				health.Status = healthUnavailable
+			}
+		}(name, check)
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	if health.Status != healthOK {
+		status = http.StatusServiceUnavailable
+		h.log.Info("service is not ready", zap.Any("components", health.Components))
+	}
+	h.respond(w, status, health)
+}
+
+func (h *HealthController) checkPostgres(ctx context.Context) models.ComponentHealth {
+	if err := h.storage.Ping(ctx); err != nil {
+		return unavailable(err)
+	}
+
+	return models.ComponentHealth{Status: healthOK}
+}
+
+func (h *HealthController) checkMigrations(ctx context.Context) models.ComponentHealth {
+	version, err := h.storage.SchemaVersion(ctx)
+	if err != nil {
+		return unavailable(err)
+	}
+
+	res := models.ComponentHealth{
+		Status:  healthOK,
+		Details: map[string]any{"version": version.Version, "expected": version.Expected, "dirty": version.Dirty},
+	}
+	switch {
+	case version.Dirty:
+		res.Status, res.Error = healthUnavailable, "a migration failed halfway"
+	case version.Version < version.Expected:
+		res.Status, res.Error = healthUnavailable, "the schema is older than this build expects"
+	}
+
+	return res
+}
+
+func (h *HealthController) checkKafka(ctx context.Context) models.ComponentHealth {
+	if err := h.kafka.Ping(ctx); err != nil {
+		return unavailable(err)
+	}
+
+	return models.ComponentHealth{Status: healthOK}
+}
+
+func (h *HealthController) checkPool(_ context.Context) models.ComponentHealth {
+	depth, capacity := h.pool.QueueDepth(), h.pool.QueueCapacity()
+
+	res := models.ComponentHealth{
+		Status:  healthOK,
+		Details: map[string]any{"queued": depth, "capacity": capacity},
+	}
+	if capacity > 0 && float64(depth) >= maxPoolSaturation*float64(capacity) {
+		res.Status, res.Error = healthUnavailable, "the task queue is saturated"
+	}
+
+	return res
+}
+
+func (h *HealthController) checkRelay(_ context.Context) models.ComponentHealth {
+	last := h.relay.LastTick()
+	stale := max(relayStaleTicks*h.relay.TickInterval(), minRelayStale)
+
+	res := models.ComponentHealth{
+		Status:  healthOK,
+		Details: map[string]any{"last_tick": last},
+	}
+	if since := time.Since(last); since > stale {
+		res.Status, res.Error = healthUnavailable, fmt.Sprintf("no successful tick for %s", since.Round(time.Second))
+	}
+
+	return res
+}
+
+func unavailable(err error) models.ComponentHealth {
+	return models.ComponentHealth{Status: healthUnavailable, Error: err.Error()}
+}
+
+func (h *HealthController) respond(w http.ResponseWriter, status int, health models.Health) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(health); err != nil {
+		h.log.Info("error encoding response: ", zap.Error(err))
+	}
+}
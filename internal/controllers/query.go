@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wurt83ow/gophstream/internal/models"
+)
+
+const (
+	// defaultPageLimit is the number of messages listed when no limit is given
+	defaultPageLimit = 100
+	// maxPageLimit is the largest accepted limit
+	maxPageLimit = 1000
+)
+
+// parseMessageQuery reads the filter, sort and pagination of a messages listing
+func parseMessageQuery(q url.Values) (models.Filter, models.Sort, models.Pagination, error) {
+	var filter models.Filter
+	sort := models.Sort{Field: models.SortByID}
+	pagination := models.Pagination{Limit: defaultPageLimit}
+	var err error
+
+	if v := q.Get("status"); v != "" {
+		for _, s := range strings.Split(v, ",") {
+			status, err := models.ParseMessageStatus(strings.TrimSpace(s))
+			if err != nil {
+				return filter, sort, pagination, err
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+
+	if filter.CreatedFrom, err = parseTime(q, "created_from"); err != nil {
+		return filter, sort, pagination, err
+	}
+	if filter.CreatedTo, err = parseTime(q, "created_to"); err != nil {
+		return filter, sort, pagination, err
+	}
+	if filter.MinID, err = parseInt(q, "min_id"); err != nil {
+		return filter, sort, pagination, err
+	}
+	if filter.MaxID, err = parseInt(q, "max_id"); err != nil {
+		return filter, sort, pagination, err
+	}
+
+	filter.Contains = q.Get("q")
+	filter.Search = q.Get("search")
+	filter.Topic = q.Get("topic")
+	filter.Type = q.Get("type")
+
+	for _, v := range q["header"] {
+		name, value, ok := strings.Cut(v, ":")
+		if !ok || name == "" {
+			return filter, sort, pagination, fmt.Errorf("header must be given as name:value, got %q", v)
+		}
+		if filter.Headers == nil {
+			filter.Headers = make(map[string]string)
+		}
+		filter.Headers[name] = value
+	}
+
+	if v := q.Get("sort"); v != "" {
+		if sort.Field, err = models.ParseSortField(v); err != nil {
+			return filter, sort, pagination, err
+		}
+	}
+	switch v := q.Get("order"); v {
+	case "", "asc":
+	case "desc":
+		sort.Desc = true
+	default:
+		return filter, sort, pagination, fmt.Errorf("order must be asc or desc, got %q", v)
+	}
+
+	limit, err := parseInt(q, "limit")
+	if err != nil {
+		return filter, sort, pagination, err
+	}
+	if limit != nil {
+		if *limit < 1 || *limit > maxPageLimit {
+			return filter, sort, pagination, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+		pagination.Limit = *limit
+	}
+
+	offset, err := parseInt(q, "offset")
+	if err != nil {
+		return filter, sort, pagination, err
+	}
+	if offset != nil {
+		if *offset < 0 {
+			return filter, sort, pagination, fmt.Errorf("offset must not be negative")
+		}
+		pagination.Offset = *offset
+	}
+
+	// an empty cursor requests the first page in keyset mode
+	if q.Has("cursor") {
+		if offset != nil {
+			return filter, sort, pagination, fmt.Errorf("offset cannot be combined with cursor")
+		}
+		if v := q.Get("sort"); v != "" && sort.Field != models.SortByCreatedAt {
+			return filter, sort, pagination, fmt.Errorf("cursor pages are sorted by created_at, got sort %q", v)
+		}
+		sort.Field = models.SortByCreatedAt
+		pagination.Keyset = true
+
+		if v := q.Get("cursor"); v != "" {
+			cursor, err := decodeCursor(v)
+			if err != nil {
+				return filter, sort, pagination, err
+			}
+			// the order of the first page is kept on the following ones
+			if q.Get("order") == "" {
+				sort.Desc = cursor.Desc
+			} else if sort.Desc != cursor.Desc {
+				return filter, sort, pagination, fmt.Errorf("order does not match the cursor")
+			}
+			pagination.After = &cursor
+		}
+	}
+
+	return filter, sort, pagination, nil
+}
+
+// encodeCursor returns the opaque cursor of the page following the message
+func encodeCursor(message models.Message, desc bool) string {
+	data, _ := json.Marshal(models.Cursor{CreatedAt: message.CreatedAt, ID: message.ID, Desc: desc})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor reads a cursor returned by encodeCursor
+func decodeCursor(s string) (models.Cursor, error) {
+	var cursor models.Cursor
+
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(data, &cursor)
+	}
+	if err != nil || cursor.ID == 0 {
+		return cursor, fmt.Errorf("invalid cursor %q", s)
+	}
+
+	return cursor, nil
+}
+
+// parseInt reads an optional integer query parameter
+func parseInt(q url.Values, name string) (*int, error) {
+	v := q.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s format %q", name, v)
+	}
+
+	return &n, nil
+}
+
+// parseTime reads an optional RFC 3339 time query parameter
+func parseTime(q url.Values, name string) (*time.Time, error) {
+	v := q.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s format %q, expected RFC 3339", name, v)
+	}
+
+	return &t, nil
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/wurt83ow/gophstream/internal/models"
+)
+
+func TestCursor(t *testing.T) {
+	createdAt := time.Date(2024, 5, 1, 12, 30, 0, 123456789, time.UTC)
+
+	tests := []struct {
+		name    string
+		message models.Message
+		desc    bool
+	}{
+		{name: "ascending", message: models.Message{ID: 42, CreatedAt: createdAt}},
+		{name: "descending", message: models.Message{ID: 7, CreatedAt: createdAt}, desc: true},
+		{name: "other zone", message: models.Message{ID: 1, CreatedAt: createdAt.In(time.FixedZone("UTC+3", 3*3600))}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := encodeCursor(tt.message, tt.desc)
+			if _, err := url.ParseQuery("cursor=" + s); err != nil || url.QueryEscape(s) != s {
+				t.Errorf("cursor %q is not safe in a query string", s)
+			}
+
+			cursor, err := decodeCursor(s)
+			if err != nil {
+				t.Fatalf("decodeCursor(%q) error = %v", s, err)
+			}
+			if cursor.ID != tt.message.ID || !cursor.CreatedAt.Equal(tt.message.CreatedAt) || cursor.Desc != tt.desc {
+				t.Errorf("decodeCursor() = %+v, want ID %d, created at %s, desc %v",
+					cursor, tt.message.ID, tt.message.CreatedAt, tt.desc)
+			}
+		})
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{name: "not base64", cursor: "!!!"},
+		{name: "padded base64", cursor: base64.URLEncoding.EncodeToString([]byte(`{"id":1}`))},
+		{name: "not json", cursor: base64.RawURLEncoding.EncodeToString([]byte("id=1"))},
+		{name: "no id", cursor: base64.RawURLEncoding.EncodeToString([]byte(`{"t":"2024-05-01T00:00:00Z"}`))},
+		{name: "bad time", cursor: base64.RawURLEncoding.EncodeToString([]byte(`{"t":"yesterday","id":1}`))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if cursor, err := decodeCursor(tt.cursor); err == nil {
+				t.Errorf("decodeCursor(%q) = %+v, want an error", tt.cursor, cursor)
+			}
+		})
+	}
+}
+
+func TestParseMessageQueryCursor(t *testing.T) {
+	createdAt := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	asc := encodeCursor(models.Message{ID: 5, CreatedAt: createdAt}, false)
+	desc := encodeCursor(models.Message{ID: 5, CreatedAt: createdAt}, true)
+
+	tests := []struct {
+		name      string
+		query     string
+		wantErr   bool
+		wantDesc  bool
+		wantAfter bool
+	}{
+		{name: "first page", query: "cursor="},
+		{name: "first page descending", query: "cursor=&order=desc", wantDesc: true},
+		{name: "next page", query: "cursor=" + asc, wantAfter: true},
+		{name: "order taken from the cursor", query: "cursor=" + desc, wantDesc: true, wantAfter: true},
+		{name: "order matches the cursor", query: "cursor=" + desc + "&order=desc", wantDesc: true, wantAfter: true},
+		{name: "order does not match the cursor", query: "cursor=" + desc + "&order=asc", wantErr: true},
+		{name: "sorted by created_at", query: "cursor=&sort=created_at"},
+		{name: "sorted by another field", query: "cursor=&sort=id", wantErr: true},
+		{name: "with offset", query: "cursor=&offset=10", wantErr: true},
+		{name: "invalid cursor", query: "cursor=abc", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, sort, pagination, err := parseMessageQuery(q)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMessageQuery(%q) error = %v, want error %v", tt.query, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !pagination.Keyset || sort.Field != models.SortByCreatedAt {
+				t.Errorf("keyset = %v, sort = %q, want keyset pages sorted by created_at", pagination.Keyset, sort.Field)
+			}
+			if sort.Desc != tt.wantDesc {
+				t.Errorf("desc = %v, want %v", sort.Desc, tt.wantDesc)
+			}
+			if (pagination.After != nil) != tt.wantAfter {
+				t.Errorf("after = %+v, want a cursor %v", pagination.After, tt.wantAfter)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/wurt83ow/gophstream/internal/models"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultStatsWindow is the window the statistics are computed over when none is given
+	defaultStatsWindow = time.Hour
+	// maxStatsWindow is the longest accepted window
+	maxStatsWindow = 30 * 24 * time.Hour
+	// maxStatsBuckets is the most throughput buckets a window may be divided into
+	maxStatsBuckets = 1440
+)
+
+// @Summary Get statistics
+// @Description Get the number of messages by status and the failures by error class,
+// @Description with the throughput and the average and p95 time from creation to sending over a window.
+// @Description The statistics may be up to 10 seconds old.
+// @Tags Messages
+// @Produce json
+// @Param window query string false "Window of the throughput and latency, e.g. 15m or 24h, defaults to 1h"
+// @Param bucket query string false "Width of the throughput buckets (minute, hour), defaults to minute"
+// @Success 200 {object} models.Stats "Statistics of processed messages"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/stats [get]
+func (h *BaseController) GetStats(w http.ResponseWriter, r *http.Request) {
+	query, err := parseStatsQuery(r.URL.Query())
+	if err != nil {
+		h.log.Info("invalid stats query", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stats, err := h.storage.GetStats(r.Context(), query)
+	if err != nil {
+		h.log.Info("error getting stats: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		h.log.Info("error encoding response: ", zap.Error(err))
+		return
+	}
+}
+
+// parseStatsQuery reads the window and bucket of the statistics from the query parameters
+func parseStatsQuery(values url.Values) (models.StatsQuery, error) {
+	query := models.StatsQuery{Window: defaultStatsWindow, Bucket: models.StatsBucketMinute}
+
+	if v := values.Get("window"); v != "" {
+		window, err := time.ParseDuration(v)
+		if err != nil || window <= 0 || window > maxStatsWindow {
+			return models.StatsQuery{}, fmt.Errorf("window must be a positive duration up to %s, got %q", maxStatsWindow, v)
+		}
+		query.Window = window
+	}
+
+	switch bucket := models.StatsBucket(values.Get("bucket")); bucket {
+	case "":
+	case models.StatsBucketMinute, models.StatsBucketHour:
+		query.Bucket = bucket
+	default:
+		return models.StatsQuery{}, fmt.Errorf("bucket must be minute or hour, got %q", bucket)
+	}
+
+	if query.Window/query.Bucket.Duration() > maxStatsBuckets {
+		return models.StatsQuery{}, fmt.Errorf("window %s has more than %d %s buckets", query.Window, maxStatsBuckets, query.Bucket)
+	}
+
+	return query, nil
+}
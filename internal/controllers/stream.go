@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wurt83ow/gophstream/internal/models"
+	"go.uber.org/zap"
+)
+
+const (
+	// lastEventIDHeader is sent by a reconnecting EventSource with the ID of the last event it received
+	lastEventIDHeader = "Last-Event-ID"
+	// streamBackfillBatch is the number of missed events read at a time when a stream resumes
+	streamBackfillBatch = 500
+	// streamHeartbeat is how often a comment is sent to keep idle connections open
+	streamHeartbeat = 15 * time.Second
+	// streamRetry is the reconnection delay suggested to the client, in milliseconds
+	streamRetry = 3000
+)
+
+// streamStatuses are the statuses message events are published for
+var streamStatuses = []models.MessageStatus{models.StatusSent, models.StatusFailed, models.StatusDead}
+
+// @Summary Stream message events
+// @Description Push an event every time a message is sent or fails, as Server-Sent Events.
+// @Description Events of all instances are delivered. A client resuming with the Last-Event-ID header
+// @Description (or the last_event_id parameter) first receives the events it missed.
+// @Tags Messages
+// @Produce text/event-stream
+// @Param status query string false "Comma separated statuses to receive (sent, failed, dead)"
+// @Param last_event_id query int false "ID of the last event received"
+// @Param Last-Event-ID header int false "ID of the last event received"
+// @Success 200 {object} models.MessageEvent "Stream of message events"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/messages/stream [get]
+func (h *BaseController) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	statuses, err := parseStatuses(r.URL.Query().Get("status"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lastID, resume, err := lastEventID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// the stream lives longer than the write timeout of the server
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		h.log.Info("cannot extend write deadline for stream: ", zap.Error(err))
+	}
+
+	// subscribe before reading the missed events, so nothing committed in between is lost
+	events, unsubscribe := h.feed.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry)
+	flusher.Flush()
+
+	send := func(event models.MessageEvent) error {
+		if len(statuses) != 0 && !statuses[event.Status] {
+			return nil
+		}
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Status, data)
+		return err
+	}
+
+	// the events read back may also arrive from the subscription
+	var replayed map[int64]bool
+	if resume {
+		replayed = make(map[int64]bool)
+		for {
+			missed, err := h.storage.GetMessageEvents(r.Context(), lastID, streamBackfillBatch)
+			if err != nil {
+				h.log.Info("error reading missed message events: ", zap.Error(err))
+				return
+			}
+			for _, event := range missed {
+				if err := send(event); err != nil {
+					return
+				}
+				replayed[event.ID] = true
+				lastID = event.ID
+			}
+			if len(missed) < streamBackfillBatch {
+				break
+			}
+		}
+		flusher.Flush()
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event, ok := <-events:
+			if !ok {
+				// dropped for falling behind, the client reconnects with Last-Event-ID
+				return
+			}
+			if replayed[event.ID] {
+				continue
+			}
+			if err := send(event); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// parseStatuses parses a comma separated list of statuses into a set,
+// only the statuses that events are published for are accepted
+func parseStatuses(value string) (map[models.MessageStatus]bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	statuses := make(map[models.MessageStatus]bool)
+	for _, s := range strings.Split(value, ",") {
+		status := models.MessageStatus(strings.TrimSpace(s))
+		if !slices.Contains(streamStatuses, status) {
+			return nil, fmt.Errorf("status must be one of %v, got %q", streamStatuses, s)
+		}
+		statuses[status] = true
+	}
+
+	return statuses, nil
+}
+
+// lastEventID returns the ID of the last event the client received, if it is resuming
+func lastEventID(r *http.Request) (int64, bool, error) {
+	value := r.Header.Get(lastEventIDHeader)
+	if value == "" {
+		value = r.URL.Query().Get("last_event_id")
+	}
+	if value == "" {
+		return 0, false, nil
+	}
+
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id < 0 {
+		return 0, false, fmt.Errorf("invalid last event ID %q", value)
+	}
+
+	return id, true, nil
+}
@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/wurt83ow/gophstream/internal/models"
+)
+
+// fakeFeed delivers its events to the subscriber, then closes the subscription
+type fakeFeed struct {
+	events []models.MessageEvent
+}
+
+func (f fakeFeed) Subscribe() (<-chan models.MessageEvent, func()) {
+	ch := make(chan models.MessageEvent, len(f.events))
+	for _, event := range f.events {
+		ch <- event
+	}
+	close(ch)
+
+	return ch, func() {}
+}
+
+func TestParseStatuses(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    map[models.MessageStatus]bool
+		wantErr bool
+	}{
+		{value: ""},
+		{value: "sent", want: map[models.MessageStatus]bool{models.StatusSent: true}},
+		{value: "failed, dead", want: map[models.MessageStatus]bool{models.StatusFailed: true, models.StatusDead: true}},
+		{value: "sent,sent", want: map[models.MessageStatus]bool{models.StatusSent: true}},
+		{value: "queued", wantErr: true},
+		{value: "in_flight", wantErr: true},
+		{value: "sent,unknown", wantErr: true},
+		{value: "sent,", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseStatuses(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseStatuses(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseStatuses(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	// stored holds the events committed before the client connected
+	stored := []models.MessageEvent{
+		{ID: 1, MessageID: 1, Status: models.StatusSent},
+		{ID: 2, MessageID: 2, Status: models.StatusFailed},
+		{ID: 3, MessageID: 3, Status: models.StatusSent},
+	}
+	// live holds the events of the subscription, the first one was also read back
+	live := []models.MessageEvent{
+		{ID: 3, MessageID: 3, Status: models.StatusSent},
+		{ID: 4, MessageID: 2, Status: models.StatusDead},
+		{ID: 5, MessageID: 4, Status: models.StatusSent},
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		header     string
+		wantStatus int
+		want       []string
+	}{
+		{name: "live only", wantStatus: http.StatusOK, want: []string{"3", "4", "5"}},
+		{name: "resume with header", header: "1", wantStatus: http.StatusOK, want: []string{"2", "3", "4", "5"}},
+		{name: "resume with parameter", query: "last_event_id=0", wantStatus: http.StatusOK, want: []string{"1", "2", "3", "4", "5"}},
+		{name: "header wins over parameter", query: "last_event_id=0", header: "2", wantStatus: http.StatusOK, want: []string{"3", "4", "5"}},
+		{name: "filtered", query: "status=sent&last_event_id=0", wantStatus: http.StatusOK, want: []string{"1", "3", "5"}},
+		{name: "filtered failures", query: "status=failed,dead", header: "0", wantStatus: http.StatusOK, want: []string{"2", "4"}},
+		{name: "status without events", query: "status=queued", wantStatus: http.StatusBadRequest},
+		{name: "invalid last event ID", header: "-1", wantStatus: http.StatusBadRequest},
+	}
+
+	eventID := regexp.MustCompile(`(?m)^id: (\d+)$`)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			storage.events = stored
+			h := NewBaseController(context.Background(), storage, fakeFeed{events: live},
+				option(""), option("1h"), option("3"), nopLog{})
+
+			r := httptest.NewRequest(http.MethodGet, "/api/messages/stream?"+tt.query, nil)
+			if tt.header != "" {
+				r.Header.Set(lastEventIDHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			h.StreamEvents(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var got []string
+			for _, m := range eventID.FindAllStringSubmatch(w.Body.String(), -1) {
+				got = append(got, m[1])
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("event IDs = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/wurt83ow/gophstream/internal/models"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// subscriberBuffer is the number of events a subscriber may fall behind
+	// before it is dropped and has to resume with Last-Event-ID
+	subscriberBuffer = 256
+	// minListenDelay and maxListenDelay bound the wait before listening again after a failure
+	minListenDelay = time.Second
+	maxListenDelay = 30 * time.Second
+	// eventRetention is how long recorded events can be resumed from
+	eventRetention = 24 * time.Hour
+	// purgeInterval is how often events older than eventRetention are deleted
+	purgeInterval = time.Hour
+)
+
+// Source is the database the events are read from
+type Source interface {
+	ListenMessageEvents(context.Context, func(models.MessageEvent)) error
+	PurgeMessageEvents(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Log interface for logging
+type Log interface {
+	Info(string, ...zapcore.Field)
+}
+
+// Broker listens for the message events of all instances and fans them out
+// to the subscribers of this instance
+type Broker struct {
+	ctx         context.Context
+	cancelFunc  context.CancelFunc
+	wg          sync.WaitGroup
+	source      Source
+	mx          sync.Mutex
+	subscribers map[chan models.MessageEvent]struct{}
+	log         Log
+}
+
+func NewBroker(ctx context.Context, source Source, log Log) *Broker {
+	return &Broker{
+		ctx:         ctx,
+		source:      source,
+		subscribers: make(map[chan models.MessageEvent]struct{}),
+		log:         log,
+	}
+}
+
+func (b *Broker) Start() {
+	b.ctx, b.cancelFunc = context.WithCancel(b.ctx)
+	b.wg.Add(2)
+	go b.listen(b.ctx)
+	go b.purge(b.ctx)
+}
+
+func (b *Broker) Stop() {
+	if b.cancelFunc != nil {
+		b.cancelFunc()
+	}
+	b.wg.Wait()
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Subscribe returns a channel receiving the events published from now on and
+// a function to unsubscribe. The channel is closed when the subscriber falls
+// too far behind or the broker stops.
+func (b *Broker) Subscribe() (<-chan models.MessageEvent, func()) {
+	ch := make(chan models.MessageEvent, subscriberBuffer)
+
+	b.mx.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mx.Unlock()
+
+	return ch, func() {
+		b.mx.Lock()
+		defer b.mx.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (b *Broker) publish(event models.MessageEvent) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// never block the listener on a slow client
+			delete(b.subscribers, ch)
+			close(ch)
+			b.log.Info("dropped slow event subscriber", zap.Int64("event_id", event.ID))
+		}
+	}
+}
+
+// listen keeps a LISTEN connection open, reconnecting with a growing delay after failures
+func (b *Broker) listen(ctx context.Context) {
+	defer b.wg.Done()
+
+	delay := minListenDelay
+	for {
+		started := time.Now()
+		err := b.source.ListenMessageEvents(ctx, b.publish)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(started) > maxListenDelay {
+			delay = minListenDelay
+		}
+		b.log.Info("listening for message events failed: ", zap.Error(err), zap.Duration("retry_in", delay))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxListenDelay)
+	}
+}
+
+// purge deletes the events that can no longer be resumed from
+func (b *Broker) purge(ctx context.Context) {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := b.source.PurgeMessageEvents(ctx, time.Now().Add(-eventRetention))
+			if err != nil {
+				continue
+			}
+			if n > 0 {
+				b.log.Info("Message events purged", zap.Int64("count", n))
+			}
+		}
+	}
+}
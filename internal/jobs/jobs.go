@@ -0,0 +1,115 @@
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/wurt83ow/gophstream/internal/models"
+)
+
+const (
+	// retention is how long a completed job can still be polled
+	retention = time.Hour
+	// maxErrors is the number of task errors kept for a job
+	maxErrors = 20
+)
+
+// Tracker keeps the progress of background jobs in memory.
+// Jobs are only visible on the instance that created them.
+type Tracker struct {
+	mx   sync.RWMutex
+	jobs map[string]*models.Job
+}
+
+// NewTracker creates a new Tracker instance
+func NewTracker() *Tracker {
+	return &Tracker{
+		jobs: make(map[string]*models.Job),
+	}
+}
+
+// Create registers a new job with the given number of tasks
+func (t *Tracker) Create(kind string, total int) models.Job {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	t.evict()
+
+	job := &models.Job{
+		ID:        newID(),
+		Kind:      kind,
+		State:     models.JobRunning,
+		Total:     total,
+		CreatedAt: time.Now(),
+	}
+	if total == 0 {
+		job.State = models.JobCompleted
+		job.FinishedAt = &job.CreatedAt
+	}
+	t.jobs[job.ID] = job
+
+	return *job
+}
+
+// Done records the result of one task of the job
+func (t *Tracker) Done(id string, err error) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	job, ok := t.jobs[id]
+	if !ok {
+		return
+	}
+
+	if err != nil {
+		job.Failed++
+		if len(job.Errors) < maxErrors {
+			job.Errors = append(job.Errors, err.Error())
+		}
+	} else {
+		job.Succeeded++
+	}
+
+	if job.Succeeded+job.Failed >= job.Total {
+		now := time.Now()
+		job.State = models.JobCompleted
+		job.FinishedAt = &now
+	}
+}
+
+// Get returns a snapshot of the job with the given ID
+func (t *Tracker) Get(id string) (models.Job, bool) {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+
+	job, ok := t.jobs[id]
+	if !ok {
+		return models.Job{}, false
+	}
+
+	res := *job
+	res.Errors = append([]string(nil), job.Errors...)
+
+	return res, true
+}
+
+// evict removes completed jobs older than the retention period
+func (t *Tracker) evict() {
+	for id, job := range t.jobs {
+		if job.FinishedAt != nil && time.Since(*job.FinishedAt) > retention {
+			delete(t.jobs, id)
+		}
+	}
+}
+
+// newID returns a random job identifier
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().Format("20060102150405.000000000")
+	}
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,227 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/wurt83ow/gophstream/internal/models"
+	"go.uber.org/zap"
+)
+
+// pendingMessage is a message waiting in the batch together with the
+// callback that receives the result of writing it
+type pendingMessage struct {
+	message models.OutgoingMessage
+	done    DeliveryFunc
+}
+
+// Batcher accumulates outgoing messages and writes them to Kafka with a single
+// WriteMessages call once the batch holds BatchSize messages or BatchBytes bytes,
+// or the first message of the batch has waited for BatchTimeout
+type Batcher struct {
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+	wg         sync.WaitGroup
+	stopping   chan struct{}
+	producer   *KafkaProducerImpl
+	messages   chan pendingMessage
+	maxSize    int
+	maxBytes   int
+	linger     time.Duration
+	log        Log
+}
+
+func NewBatcher(ctx context.Context, producer *KafkaProducerImpl, cfg Config, log Log) *Batcher {
+	return &Batcher{
+		ctx:      ctx,
+		stopping: make(chan struct{}),
+		producer: producer,
+		messages: make(chan pendingMessage, cfg.BatchSize),
+		maxSize:  cfg.BatchSize,
+		maxBytes: cfg.BatchBytes,
+		linger:   cfg.BatchTimeout,
+		log:      log,
+	}
+}
+
+func (b *Batcher) Start() {
+	b.ctx, b.cancelFunc = context.WithCancel(b.ctx)
+	b.wg.Add(1)
+	go b.run(b.ctx)
+}
+
+// Stop writes the messages already added and stops the batcher once their
+// callbacks have run. Messages not written when ctx is done are reported as failed.
+func (b *Batcher) Stop(ctx context.Context) {
+	if b.cancelFunc == nil {
+		return
+	}
+
+	close(b.stopping)
+	cancel := context.AfterFunc(ctx, b.cancelFunc)
+	defer cancel()
+
+	b.wg.Wait()
+	b.cancelFunc()
+}
+
+// SendMessageAsync adds a message to the current batch. done is called with the
+// result of the write from another goroutine, a nil error means Kafka acknowledged it.
+func (b *Batcher) SendMessageAsync(ctx context.Context, message models.OutgoingMessage, done DeliveryFunc) {
+	span, message := startPublish(ctx, message)
+	done = tracedDelivery(span, done)
+
+	select {
+	case b.messages <- pendingMessage{message: message, done: done}:
+	case <-ctx.Done():
+		done(models.Delivery{}, ctx.Err())
+	case <-b.ctx.Done():
+		done(models.Delivery{}, b.ctx.Err())
+	}
+}
+
+// Available returns the number of messages that can be added without waiting
+func (b *Batcher) Available() int {
+	return cap(b.messages) - len(b.messages)
+}
+
+func (b *Batcher) run(ctx context.Context) {
+	defer b.wg.Done()
+
+	var (
+		batch []pendingMessage
+		bytes int
+		timer *time.Timer
+		flush <-chan time.Time
+	)
+
+	send := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, flush = nil, nil
+		}
+		b.write(ctx, batch)
+		batch, bytes = nil, 0
+	}
+
+	add := func(p pendingMessage) {
+		size := messageSize(p.message)
+		if len(batch) != 0 && bytes+size > b.maxBytes {
+			send()
+		}
+
+		batch = append(batch, p)
+		bytes += size
+		if timer == nil {
+			timer = time.NewTimer(b.linger)
+			flush = timer.C
+		}
+
+		if len(batch) >= b.maxSize || bytes >= b.maxBytes {
+			send()
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// the messages of an unfinished batch stay claimed until their lease expires
+			b.complete(batch, time.Time{}, nil, nil, ctx.Err())
+			return
+		case <-b.stopping:
+			// write the messages added before the batcher was stopped without waiting
+			for {
+				select {
+				case p := <-b.messages:
+					add(p)
+				default:
+					if len(batch) != 0 {
+						send()
+					}
+					return
+				}
+			}
+		case p := <-b.messages:
+			add(p)
+		case <-flush:
+			timer, flush = nil, nil
+			send()
+		}
+	}
+}
+
+// write sends the batch with a single WriteMessages call and reports the
+// result of every message. The callbacks run in the background so that
+// the next batch can be filled while the results are handled.
+func (b *Batcher) write(ctx context.Context, batch []pendingMessage) {
+	messages := make([]kafka.Message, len(batch))
+	reports := make([]*deliveryReport, len(batch))
+	for i, p := range batch {
+		reports[i] = &deliveryReport{}
+		messages[i] = toKafkaMessage(p.message)
+		messages[i].WriterData = reports[i]
+	}
+
+	start := time.Now()
+	err := b.producer.writer.WriteMessages(ctx, messages...)
+	if err != nil {
+		b.log.Info("Failed to send batch", zap.Error(err), zap.Int("messages", len(batch)))
+	} else {
+		b.log.Info("Batch sent successfully", zap.Int("messages", len(batch)))
+	}
+
+	// the reports are complete once WriteMessages returned without an error or with
+	// per-message errors; otherwise the write was abandoned and may still be running
+	var werr kafka.WriteErrors
+	if err == nil || errors.As(err, &werr) && len(werr) == len(batch) {
+		b.complete(batch, start, reports, werr, nil)
+		return
+	}
+	b.complete(batch, start, nil, nil, err)
+}
+
+// complete calls the callbacks of the batch with the delivery or error of each
+// message, or with err for all of them when the write failed as a whole.
+// start is when the batch was written, zero if it never was.
+func (b *Batcher) complete(batch []pendingMessage, start time.Time, reports []*deliveryReport, errs kafka.WriteErrors, err error) {
+	if len(batch) == 0 {
+		return
+	}
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+
+		for i, p := range batch {
+			var delivery models.Delivery
+			var derr error
+
+			switch {
+			case errs != nil && errs[i] != nil:
+				derr = errs[i]
+			case reports != nil && reports[i].reported:
+				delivery, derr = reports[i].delivery, reports[i].err
+			case reports != nil:
+				derr = errors.New("kafka did not report the delivery of the message")
+			default:
+				derr = err
+			}
+
+			observeSend("batch", p.message.Topic, start, derr)
+			p.done(delivery, derr)
+		}
+	}()
+}
+
+// messageSize estimates the size of a message in a produce request
+func messageSize(message models.OutgoingMessage) int {
+	size := len(message.Key) + len(message.Value)
+	for name, value := range message.Headers {
+		size += len(name) + len(value)
+	}
+
+	return size
+}
@@ -0,0 +1,158 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// Config holds the Kafka client settings shared by the producer and the consumer
+type Config struct {
+	Brokers      []string
+	Topic        string
+	ClientID     string
+	RequiredAcks string
+	Compression  string
+	BatchSize    int
+	BatchBytes   int
+	BatchTimeout time.Duration
+	Async        bool
+	// Dedupe disables the retries of the writer, a write with an unknown
+	// outcome is looked up in the topic before it is sent again. kafka-go has
+	// no idempotent or transactional producer, so delivery stays at-least-once.
+	Dedupe bool
+
+	SASLMechanism string
+	SASLUsername  string
+	SASLPassword  string
+
+	TLSEnabled            bool
+	TLSCAFile             string
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSInsecureSkipVerify bool
+}
+
+// requiredAcks converts the configured acks to the kafka-go value
+func (c Config) requiredAcks() kafka.RequiredAcks {
+	switch c.RequiredAcks {
+	case "none":
+		return kafka.RequireNone
+	case "one":
+		return kafka.RequireOne
+	default:
+		return kafka.RequireAll
+	}
+}
+
+// compression converts the configured codec to the kafka-go value
+func (c Config) compression() kafka.Compression {
+	switch c.Compression {
+	case "gzip":
+		return kafka.Gzip
+	case "snappy":
+		return kafka.Snappy
+	case "lz4":
+		return kafka.Lz4
+	case "zstd":
+		return kafka.Zstd
+	default:
+		return 0
+	}
+}
+
+// mechanism returns the configured SASL mechanism or nil if SASL is disabled
+func (c Config) mechanism() (sasl.Mechanism, error) {
+	switch c.SASLMechanism {
+	case "":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: c.SASLUsername, Password: c.SASLPassword}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, c.SASLUsername, c.SASLPassword)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, c.SASLUsername, c.SASLPassword)
+	default:
+		return nil, fmt.Errorf("unsupported SASL mechanism %q", c.SASLMechanism)
+	}
+}
+
+// tlsConfig returns the configured TLS settings or nil if TLS is disabled
+func (c Config) tlsConfig() (*tls.Config, error) {
+	if !c.TLSEnabled {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.TLSInsecureSkipVerify,
+	}
+
+	if c.TLSCAFile != "" {
+		ca, err := os.ReadFile(c.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read Kafka CA file: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in Kafka CA file %s", c.TLSCAFile)
+		}
+	}
+
+	if c.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load Kafka client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// transport builds the transport used by the producer
+func (c Config) transport() (*kafka.Transport, error) {
+	mechanism, err := c.mechanism()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsCfg, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return &kafka.Transport{
+		ClientID: c.ClientID,
+		SASL:     mechanism,
+		TLS:      tlsCfg,
+	}, nil
+}
+
+// dialer builds the dialer used by the consumer
+func (c Config) dialer() (*kafka.Dialer, error) {
+	mechanism, err := c.mechanism()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsCfg, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return &kafka.Dialer{
+		ClientID:      c.ClientID,
+		Timeout:       10 * time.Second,
+		DualStack:     true,
+		SASLMechanism: mechanism,
+		TLS:           tlsCfg,
+	}, nil
+}
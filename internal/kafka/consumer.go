@@ -0,0 +1,209 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/wurt83ow/gophstream/internal/models"
+	"github.com/wurt83ow/gophstream/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// retryDelay is the pause before fetching or storing again after an error
+const retryDelay = time.Second
+
+// ReceiptStorage interface for recording delivery receipts
+type ReceiptStorage interface {
+	InsertReceipt(context.Context, models.Receipt) (bool, error)
+}
+
+// KafkaConsumer reads processed messages back from Kafka as a member of a
+// consumer group and records a delivery receipt for each of them
+type KafkaConsumer struct {
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+	wg         sync.WaitGroup
+	reader     *kafka.Reader
+	storage    ReceiptStorage
+	log        Log
+	topics     []string
+	groupID    string
+
+	consumed   atomic.Int64
+	receipts   atomic.Int64
+	duplicates atomic.Int64
+	failures   atomic.Int64
+}
+
+// NewKafkaConsumer creates a consumer reading all topics, which are the
+// topics messages are routed to
+func NewKafkaConsumer(ctx context.Context, cfg Config, groupID string, topics []string,
+	storage ReceiptStorage, log Log,
+) (*KafkaConsumer, error) {
+	dialer, err := cfg.dialer()
+	if err != nil {
+		return nil, err
+	}
+
+	kc := &KafkaConsumer{
+		ctx: ctx,
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers:     cfg.Brokers,
+			GroupID:     groupID,
+			GroupTopics: topics,
+			Dialer:      dialer,
+		}),
+		storage: storage,
+		log:     log,
+		topics:  topics,
+		groupID: groupID,
+	}
+
+	kc.log.Info("Kafka consumer created", zap.Strings("topics", topics),
+		zap.String("group", groupID), zap.Strings("brokers", cfg.Brokers))
+	return kc, nil
+}
+
+func (kc *KafkaConsumer) Start() {
+	kc.ctx, kc.cancelFunc = context.WithCancel(kc.ctx)
+	kc.wg.Add(1)
+	go kc.consume(kc.ctx)
+}
+
+func (kc *KafkaConsumer) Stop() {
+	if kc.cancelFunc != nil {
+		kc.cancelFunc()
+	}
+	kc.wg.Wait()
+
+	if err := kc.reader.Close(); err != nil {
+		kc.log.Info("Failed to close Kafka consumer", zap.Error(err))
+	}
+}
+
+// Stats returns the current lag of the consumer and its receipt counters.
+// The lag is the one seen by the last fetch of the group, reader.Lag
+// only works for readers without a consumer group.
+func (kc *KafkaConsumer) Stats() models.ConsumerStats {
+	return models.ConsumerStats{
+		Topics:        kc.topics,
+		ConsumerGroup: kc.groupID,
+		Lag:           kc.reader.Stats().Lag,
+		Consumed:      kc.consumed.Load(),
+		Receipts:      kc.receipts.Load(),
+		Duplicates:    kc.duplicates.Load(),
+		Failures:      kc.failures.Load(),
+	}
+}
+
+func (kc *KafkaConsumer) consume(ctx context.Context) {
+	defer kc.wg.Done()
+
+	for {
+		m, err := kc.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			kc.log.Info("Failed to fetch message", zap.Error(err), zap.Strings("topics", kc.topics))
+			if !sleep(ctx, retryDelay) {
+				return
+			}
+			continue
+		}
+
+		kc.consumed.Add(1)
+
+		if err := kc.handle(ctx, m); err != nil {
+			// the context was cancelled before the receipt was stored,
+			// leave the offset uncommitted so the message is read again
+			return
+		}
+
+		if err := kc.reader.CommitMessages(ctx, m); err != nil && ctx.Err() == nil {
+			kc.log.Info("Failed to commit message", zap.Error(err),
+				zap.Int("partition", m.Partition), zap.Int64("offset", m.Offset))
+		}
+	}
+}
+
+// handle decodes a message and stores its receipt, retrying storage errors
+// until the context is cancelled. Messages that cannot be decoded are counted
+// as failures and skipped.
+func (kc *KafkaConsumer) handle(ctx context.Context, m kafka.Message) error {
+	// continue the trace the producer put in the headers
+	for _, h := range m.Headers {
+		if h.Key == tracing.TraceParentHeader {
+			ctx = tracing.ContextWithTraceParent(ctx, string(h.Value))
+		}
+	}
+	ctx, span := tracing.Tracer().Start(ctx, "receive "+m.Topic,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.String("messaging.destination.name", m.Topic),
+			attribute.String("messaging.consumer.group.name", kc.groupID),
+			attribute.Int("messaging.kafka.destination.partition", m.Partition),
+			attribute.Int64("messaging.kafka.message.offset", m.Offset),
+		),
+	)
+	defer span.End()
+
+	var message models.Message
+	if err := json.Unmarshal(m.Value, &message); err != nil {
+		tracing.RecordError(span, err)
+		kc.failures.Add(1)
+		kc.log.Info("Failed to decode message", zap.Error(err),
+			zap.Int("partition", m.Partition), zap.Int64("offset", m.Offset))
+		return nil
+	}
+
+	span.SetAttributes(attribute.Int("gophstream.message_id", message.ID))
+
+	receipt := models.Receipt{
+		MessageID:     message.ID,
+		Topic:         m.Topic,
+		Partition:     m.Partition,
+		Offset:        m.Offset,
+		ConsumerGroup: kc.groupID,
+		ConsumedAt:    time.Now(),
+	}
+
+	for {
+		inserted, err := kc.storage.InsertReceipt(ctx, receipt)
+		if err == nil {
+			if inserted {
+				kc.receipts.Add(1)
+			} else {
+				kc.duplicates.Add(1)
+			}
+			return nil
+		}
+
+		kc.failures.Add(1)
+		tracing.RecordError(span, err)
+		kc.log.Info("Failed to store receipt", zap.Error(err), zap.Int("messageID", message.ID))
+		if !sleep(ctx, retryDelay) {
+			return ctx.Err()
+		}
+	}
+}
+
+// sleep waits for d and reports false if the context was cancelled first
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,72 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/wurt83ow/gophstream/internal/models"
+)
+
+// ClassifyError reports the class of an error returned by SendMessage and
+// whether sending the same message again may succeed
+func ClassifyError(err error) (models.ErrorClass, bool) {
+	// a batch write reports one error per message, classify the first one
+	var werr kafka.WriteErrors
+	if errors.As(err, &werr) {
+		for _, e := range werr {
+			if e != nil {
+				return ClassifyError(e)
+			}
+		}
+	}
+
+	var kerr kafka.Error
+	if errors.As(err, &kerr) {
+		return classifyKafkaError(kerr)
+	}
+
+	var nerr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return models.ErrorClassTimeout, true
+	case errors.As(err, &nerr) && nerr.Timeout():
+		return models.ErrorClassTimeout, true
+	case errors.Is(err, context.Canceled):
+		return models.ErrorClassNetwork, true
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.EPIPE), errors.As(err, new(*net.OpError)), errors.As(err, new(*net.DNSError)):
+		return models.ErrorClassNetwork, true
+	}
+
+	return models.ErrorClassUnknown, true
+}
+
+// classifyKafkaError classifies an error code returned by a broker
+func classifyKafkaError(err kafka.Error) (models.ErrorClass, bool) {
+	switch err {
+	case kafka.MessageSizeTooLarge, kafka.InvalidMessage, kafka.InvalidMessageSize,
+		kafka.RecordListTooLarge, kafka.InvalidRecord,
+		kafka.InvalidTimestamp, kafka.UnsupportedForMessageFormat, kafka.PolicyViolation:
+		return models.ErrorClassMessage, false
+	case kafka.TopicAuthorizationFailed, kafka.ClusterAuthorizationFailed,
+		kafka.SASLAuthenticationFailed, kafka.UnsupportedSASLMechanism,
+		kafka.IllegalSASLState, kafka.TransactionalIDAuthorizationFailed:
+		return models.ErrorClassAuth, false
+	case kafka.InvalidTopic, kafka.InvalidRequiredAcks, kafka.UnsupportedVersion,
+		kafka.UnsupportedCompressionType, kafka.InvalidConfiguration:
+		return models.ErrorClassConfig, false
+	case kafka.RequestTimedOut:
+		return models.ErrorClassTimeout, true
+	}
+
+	if err.Temporary() {
+		return models.ErrorClassBroker, true
+	}
+
+	return models.ErrorClassUnknown, false
+}
@@ -0,0 +1,70 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/wurt83ow/gophstream/internal/models"
+)
+
+// timeoutError is a net.Error that reports a timeout
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantClass models.ErrorClass
+		wantRetry bool
+	}{
+		{name: "message too large", err: kafka.MessageSizeTooLarge, wantClass: models.ErrorClassMessage},
+		{name: "invalid record", err: kafka.InvalidRecord, wantClass: models.ErrorClassMessage},
+		{name: "invalid message", err: kafka.InvalidMessage, wantClass: models.ErrorClassMessage},
+		{name: "topic authorization", err: kafka.TopicAuthorizationFailed, wantClass: models.ErrorClassAuth},
+		{name: "sasl authentication", err: kafka.SASLAuthenticationFailed, wantClass: models.ErrorClassAuth},
+		{name: "invalid topic", err: kafka.InvalidTopic, wantClass: models.ErrorClassConfig},
+		{name: "invalid acks", err: kafka.InvalidRequiredAcks, wantClass: models.ErrorClassConfig},
+		{name: "request timed out", err: kafka.RequestTimedOut, wantClass: models.ErrorClassTimeout, wantRetry: true},
+		{name: "leader not available", err: kafka.LeaderNotAvailable, wantClass: models.ErrorClassBroker, wantRetry: true},
+		{name: "not enough replicas", err: kafka.NotEnoughReplicas, wantClass: models.ErrorClassBroker, wantRetry: true},
+		{name: "permanent broker error", err: kafka.OffsetOutOfRange, wantClass: models.ErrorClassUnknown},
+		{name: "wrapped broker error", err: fmt.Errorf("send: %w", kafka.MessageSizeTooLarge), wantClass: models.ErrorClassMessage},
+		{
+			name:      "write errors",
+			err:       kafka.WriteErrors{nil, kafka.TopicAuthorizationFailed, kafka.LeaderNotAvailable},
+			wantClass: models.ErrorClassAuth,
+		},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, wantClass: models.ErrorClassTimeout, wantRetry: true},
+		{name: "wrapped deadline", err: fmt.Errorf("write: %w", context.DeadlineExceeded), wantClass: models.ErrorClassTimeout, wantRetry: true},
+		{name: "canceled", err: context.Canceled, wantClass: models.ErrorClassNetwork, wantRetry: true},
+		{name: "net timeout", err: timeoutError{}, wantClass: models.ErrorClassTimeout, wantRetry: true},
+		{name: "eof", err: io.EOF, wantClass: models.ErrorClassNetwork, wantRetry: true},
+		{name: "connection refused", err: syscall.ECONNREFUSED, wantClass: models.ErrorClassNetwork, wantRetry: true},
+		{
+			name:      "dial error",
+			err:       &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("no route to host")},
+			wantClass: models.ErrorClassNetwork,
+			wantRetry: true,
+		},
+		{name: "unknown", err: errors.New("boom"), wantClass: models.ErrorClassUnknown, wantRetry: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			class, retry := ClassifyError(tt.err)
+			if class != tt.wantClass || retry != tt.wantRetry {
+				t.Errorf("ClassifyError(%v) = %s, %v, want %s, %v", tt.err, class, retry, tt.wantClass, tt.wantRetry)
+			}
+		})
+	}
+}
@@ -2,49 +2,214 @@ package kafka
 
 import (
 	"context"
+	"errors"
+	"sort"
+	"sync/atomic"
+	"time"
 
 	"github.com/segmentio/kafka-go"
+	"github.com/wurt83ow/gophstream/internal/models"
+	"github.com/wurt83ow/gophstream/internal/tracing"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
+// writerBatchTimeout is how long the writer waits to fill a partition batch.
+// Messages are already batched by the Batcher, so the writer flushes right away.
+const writerBatchTimeout = time.Millisecond
+
 type Log interface {
-	Info(msg string, keysAndValues ...interface{})
-	Error(msg string, err error, keysAndValues ...interface{})
+	Info(string, ...zapcore.Field)
+}
+
+// DeliveryFunc receives the result of writing a message. On success the
+// delivery holds the topic, partition and offset the message was written to.
+type DeliveryFunc func(models.Delivery, error)
+
+// deliveryReport is attached to a message as its WriterData and
+// filled in by the Completion callback of the writer
+type deliveryReport struct {
+	delivery models.Delivery
+	err      error
+	reported bool
+	notify   DeliveryFunc
+	queuedAt time.Time
 }
 
 type KafkaProducerImpl struct {
 	writer *kafka.Writer
+	async  *kafka.Writer
+	client *kafka.Client
 	log    Log
+	// pending is the number of asynchronous messages waiting for their delivery,
+	// at most maxPending are accepted by Available
+	pending    atomic.Int64
+	maxPending int
 }
 
-func NewKafkaProducer(ctx context.Context, brokers []string, topic string, log Log) *KafkaProducerImpl {
+// NewKafkaProducer creates a producer for the configured brokers.
+// The topic is chosen per message, so the writers themselves have none.
+// The asynchronous writer is only created when cfg.Async is set.
+func NewKafkaProducer(ctx context.Context, cfg Config, log Log) (*KafkaProducerImpl, error) {
+	transport, err := cfg.transport()
+	if err != nil {
+		return nil, err
+	}
+
 	kp := &KafkaProducerImpl{
-		writer: &kafka.Writer{
-			Addr:     kafka.TCP(brokers...),
-			Topic:    topic,
-			Balancer: &kafka.LeastBytes{},
-		},
-		log: log,
+		client:     &kafka.Client{Addr: kafka.TCP(cfg.Brokers...), Transport: transport},
+		log:        log,
+		maxPending: cfg.BatchSize,
+	}
+	kp.writer = kp.newWriter(cfg, transport, writerBatchTimeout)
+	if cfg.Async {
+		kp.async = kp.newWriter(cfg, transport, cfg.BatchTimeout)
+		kp.async.Async = true
+	}
+
+	kp.log.Info("Kafka producer created", zap.Strings("brokers", cfg.Brokers),
+		zap.String("clientID", cfg.ClientID), zap.String("acks", cfg.RequiredAcks),
+		zap.String("compression", cfg.Compression), zap.Bool("async", cfg.Async))
+	return kp, nil
+}
+
+// newWriter creates a writer that reports every written message to its deliveryReport.
+// Messages with a key always go to the same partition, so their order is kept.
+func (kp *KafkaProducerImpl) newWriter(cfg Config, transport *kafka.Transport, batchTimeout time.Duration) *kafka.Writer {
+	maxAttempts := 0 // the writer's default
+	if cfg.Dedupe {
+		maxAttempts = 1
+	}
+
+	return &kafka.Writer{
+		MaxAttempts:  maxAttempts,
+		Addr:         kafka.TCP(cfg.Brokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: cfg.requiredAcks(),
+		Compression:  cfg.compression(),
+		BatchSize:    cfg.BatchSize,
+		BatchBytes:   int64(cfg.BatchBytes),
+		BatchTimeout: batchTimeout,
+		Transport:    transport,
+		Completion:   kp.complete,
+	}
+}
+
+// complete is the Completion callback of the writers. All messages
+// of a call were written to the same partition with the same result.
+func (kp *KafkaProducerImpl) complete(messages []kafka.Message, err error) {
+	for _, m := range messages {
+		report, ok := m.WriterData.(*deliveryReport)
+		if !ok {
+			continue
+		}
+
+		report.delivery.Topic = m.Topic
+		report.delivery.Partition = m.Partition
+		report.delivery.Offset = m.Offset
+		report.err = err
+		report.reported = true
+
+		if report.notify != nil {
+			kp.pending.Add(-1)
+			observeSend("async", m.Topic, report.queuedAt, err)
+			report.notify(report.delivery, err)
+		}
+	}
+}
+
+// Close flushes the pending asynchronous messages and closes the writers
+func (kp *KafkaProducerImpl) Close() {
+	if kp.async != nil {
+		if err := kp.async.Close(); err != nil {
+			kp.log.Info("Failed to close async Kafka writer", zap.Error(err))
+		}
+	}
+	if err := kp.writer.Close(); err != nil {
+		kp.log.Info("Failed to close Kafka writer", zap.Error(err))
 	}
+}
 
-	kp.log.Info("Kafka producer created", "topic", topic, "brokers", brokers)
-	return kp
+// Ping checks that the brokers can be reached by requesting the cluster metadata
+// over the same connection settings as the writers
+func (kp *KafkaProducerImpl) Ping(ctx context.Context) error {
+	_, err := kp.client.Metadata(ctx, &kafka.MetadataRequest{})
+	return err
 }
 
-func (kp *KafkaProducerImpl) SendMessage(ctx context.Context, topic string, message []byte) error {
-	kp.log.Info("Sending message", "topic", topic, "message", string(message))
+// SendMessage writes a message with its key and headers to the message topic
+func (kp *KafkaProducerImpl) SendMessage(ctx context.Context, message models.OutgoingMessage) error {
+	span, message := startPublish(ctx, message)
+	defer span.End()
 
-	err := kp.writer.WriteMessages(ctx,
-		kafka.Message{
-			Topic: topic,
-			Value: message,
-		},
-	)
+	kp.log.Info("Sending message", zap.String("topic", message.Topic), zap.ByteString("message", message.Value))
 
+	start := time.Now()
+	err := kp.writer.WriteMessages(ctx, toKafkaMessage(message))
+	observeSend("sync", message.Topic, start, err)
 	if err != nil {
-		kp.log.Error("Failed to send message", err, "topic", topic, "message", string(message))
+		tracing.RecordError(span, err)
+		kp.log.Info("Failed to send message", zap.Error(err), zap.String("topic", message.Topic), zap.ByteString("message", message.Value))
 		return err
 	}
 
-	kp.log.Info("Message sent successfully", "topic", topic, "message", string(message))
+	kp.log.Info("Message sent successfully", zap.String("topic", message.Topic), zap.ByteString("message", message.Value))
 	return nil
 }
+
+// SendMessageAsync queues a message on the asynchronous writer and returns
+// immediately. done is called exactly once from a writer goroutine when Kafka
+// acknowledged the message or the write failed.
+func (kp *KafkaProducerImpl) SendMessageAsync(ctx context.Context, message models.OutgoingMessage, done DeliveryFunc) {
+	if kp.async == nil {
+		done(models.Delivery{}, errors.New("kafka producer is not running in async mode"))
+		return
+	}
+
+	span, message := startPublish(ctx, message)
+	done = tracedDelivery(span, done)
+
+	m := toKafkaMessage(message)
+	m.WriterData = &deliveryReport{notify: done, queuedAt: time.Now()}
+
+	// the completion callback is not called when the message could not be queued
+	kp.pending.Add(1)
+	if err := kp.async.WriteMessages(ctx, m); err != nil {
+		kp.pending.Add(-1)
+		kp.log.Info("Failed to queue message", zap.Error(err), zap.String("topic", message.Topic))
+		observeSend("async", message.Topic, time.Time{}, err)
+		done(models.Delivery{}, err)
+	}
+}
+
+// Available returns the number of asynchronous messages that can be queued
+// before the ones already queued are delivered
+func (kp *KafkaProducerImpl) Available() int {
+	if kp.async == nil {
+		return 0
+	}
+
+	return max(0, kp.maxPending-int(kp.pending.Load()))
+}
+
+// toKafkaMessage converts an outgoing message to the kafka-go representation.
+// Headers are sorted by name so that records are written deterministically.
+func toKafkaMessage(message models.OutgoingMessage) kafka.Message {
+	names := make([]string, 0, len(message.Headers))
+	for name := range message.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var headers []kafka.Header
+	for _, name := range names {
+		headers = append(headers, kafka.Header{Key: name, Value: []byte(message.Headers[name])})
+	}
+
+	return kafka.Message{
+		Topic:   message.Topic,
+		Key:     message.Key,
+		Headers: headers,
+		Value:   message.Value,
+	}
+}
@@ -0,0 +1,34 @@
+package kafka
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	sendDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gophstream_kafka_send_duration_seconds",
+		Help:    "Time from handing a message to the writer until Kafka acknowledged it or the write failed, by mode (sync, async, batch).",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"mode"})
+	messagesSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gophstream_kafka_messages_total",
+		Help: "Messages written to Kafka by topic and result (sent, error).",
+	}, []string{"topic", "result"})
+)
+
+// observeSend records the outcome of writing a message. A zero start means the
+// write never started and only the result is counted.
+func observeSend(mode, topic string, start time.Time, err error) {
+	result := "sent"
+	if err != nil {
+		result = "error"
+	}
+	messagesSent.WithLabelValues(topic, result).Inc()
+
+	if !start.IsZero() {
+		sendDuration.WithLabelValues(mode).Observe(time.Since(start).Seconds())
+	}
+}
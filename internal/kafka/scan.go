@@ -0,0 +1,163 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/wurt83ow/gophstream/internal/models"
+	"go.uber.org/zap"
+)
+
+const (
+	// scanMaxRecords is the largest offset range of a partition that is read
+	// back, a longer range is not scanned and ErrScanRange is returned
+	scanMaxRecords = 100_000
+	// scanFetchTimeout bounds a single fetch of a partition scan
+	scanFetchTimeout = 10 * time.Second
+	// scanFetchWait is how long the broker waits to fill a fetch
+	scanFetchWait = 500 * time.Millisecond
+	// scanFetchBytes is the largest fetch of a partition scan
+	scanFetchBytes = 1 << 20
+)
+
+// ErrScanRange is returned when the records to read back exceed scanMaxRecords
+var ErrScanRange = errors.New("too many records to scan")
+
+// Scanner reads back the records of a topic to find out whether messages whose
+// write had an unknown outcome reached Kafka.
+//
+// kafka-go has neither an idempotent nor a transactional producer, so a write
+// that timed out or a relay that crashed before recording the delivery can
+// leave a record in the topic that Postgres does not know about. Sending such
+// a message again would duplicate it; the Scanner finds the record instead.
+// This is a best-effort dedupe: delivery stays at-least-once, since a record
+// can still be written after the scan has looked for it.
+type Scanner struct {
+	brokers []string
+	dialer  *kafka.Dialer
+	log     Log
+}
+
+func NewScanner(cfg Config, log Log) (*Scanner, error) {
+	dialer, err := cfg.dialer()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Scanner{
+		brokers: cfg.Brokers,
+		dialer:  dialer,
+		log:     log,
+	}, nil
+}
+
+// FindDeliveries reads every partition of topic from the first record written
+// at or after since up to the current end of the partition, and returns where
+// the records carrying one of the message IDs in their HeaderMessageID were written.
+// A partition with more than scanMaxRecords records to read fails with ErrScanRange.
+func (s *Scanner) FindDeliveries(ctx context.Context, topic string, since time.Time,
+	messageIDs map[int]bool,
+) (map[int]models.Delivery, error) {
+	if len(s.brokers) == 0 {
+		return nil, errors.New("no Kafka brokers configured")
+	}
+
+	partitions, err := s.dialer.LookupPartitions(ctx, "tcp", s.brokers[0], topic)
+	if err != nil {
+		return nil, fmt.Errorf("cannot look up partitions of %s: %w", topic, err)
+	}
+
+	found := make(map[int]models.Delivery)
+	for _, p := range partitions {
+		if err := s.scanPartition(ctx, topic, p.ID, since, messageIDs, found); err != nil {
+			return nil, fmt.Errorf("cannot scan partition %d of %s: %w", p.ID, topic, err)
+		}
+	}
+
+	s.log.Info("Topic scanned", zap.String("topic", topic), zap.Time("since", since),
+		zap.Int("wanted", len(messageIDs)), zap.Int("found", len(found)))
+	return found, nil
+}
+
+// scanPartition reads the partition between the offsets of since and of its
+// end when the scan starts. Each fetch has a deadline, and a fetch that does
+// not move past the records already read ends the scan, since the offsets up to
+// the end may not exist as records, e.g. after compaction or at the control
+// records of transactions written by other producers.
+func (s *Scanner) scanPartition(ctx context.Context, topic string, partition int, since time.Time,
+	messageIDs map[int]bool, found map[int]models.Delivery,
+) error {
+	conn, err := s.dialer.DialLeader(ctx, "tcp", s.brokers[0], topic, partition)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	first, err := conn.ReadOffset(since)
+	if err != nil {
+		return err
+	}
+	last, err := conn.ReadLastOffset()
+	if err != nil {
+		return err
+	}
+	if first >= last {
+		return nil
+	}
+	if last-first > scanMaxRecords {
+		return fmt.Errorf("%w: %d records from offset %d", ErrScanRange, last-first, first)
+	}
+
+	if _, err := conn.Seek(first, kafka.SeekAbsolute); err != nil {
+		return err
+	}
+
+	for offset := first; offset < last; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := conn.SetReadDeadline(time.Now().Add(scanFetchTimeout)); err != nil {
+			return err
+		}
+
+		batch := conn.ReadBatchWith(kafka.ReadBatchConfig{MinBytes: 1, MaxBytes: scanFetchBytes, MaxWait: scanFetchWait})
+		for {
+			m, err := batch.ReadMessage()
+			if err != nil {
+				break
+			}
+			if id, ok := messageID(m); ok && messageIDs[id] {
+				found[id] = models.Delivery{Topic: m.Topic, Partition: m.Partition, Offset: m.Offset}
+			}
+		}
+		next := batch.Offset()
+		if err := batch.Close(); err != nil {
+			return err
+		}
+
+		if next <= offset {
+			s.log.Info("No more records before the end of the partition", zap.String("topic", topic),
+				zap.Int("partition", partition), zap.Int64("offset", offset), zap.Int64("end", last))
+			return nil
+		}
+		offset = next
+	}
+
+	return nil
+}
+
+// messageID returns the message ID carried in the headers of a record
+func messageID(m kafka.Message) (int, bool) {
+	for _, h := range m.Headers {
+		if h.Key == models.HeaderMessageID {
+			id, err := strconv.Atoi(string(h.Value))
+			return id, err == nil
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,66 @@
+package kafka
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/wurt83ow/gophstream/internal/models"
+	"github.com/wurt83ow/gophstream/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startPublish starts the producer span of a message and returns the message
+// with its traceparent header pointing at the span, so that consumers continue
+// the trace. Without a span in ctx the trace already in the header is continued.
+func startPublish(ctx context.Context, message models.OutgoingMessage) (trace.Span, models.OutgoingMessage) {
+	if !tracing.HasSpan(ctx) {
+		ctx = tracing.ContextWithTraceParent(ctx, message.Headers[tracing.TraceParentHeader])
+	}
+
+	attrs := []attribute.KeyValue{
+		attribute.String("messaging.system", "kafka"),
+		attribute.String("messaging.destination.name", message.Topic),
+		attribute.Int("messaging.message.body.size", len(message.Value)),
+	}
+	if id, err := strconv.Atoi(message.Headers[models.HeaderMessageID]); err == nil {
+		attrs = append(attrs, attribute.Int("gophstream.message_id", id))
+	}
+	ctx, span := tracing.Tracer().Start(ctx, "publish "+message.Topic,
+		trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(attrs...))
+
+	traceParent := tracing.TraceParent(ctx)
+	if traceParent == "" {
+		return span, message
+	}
+
+	// the headers belong to the caller and are copied before they are changed
+	headers := make(map[string]string, len(message.Headers)+1)
+	for name, value := range message.Headers {
+		headers[name] = value
+	}
+	headers[tracing.TraceParentHeader] = traceParent
+	message.Headers = headers
+
+	return span, message
+}
+
+// endPublish ends the producer span of a message with the result of writing it
+func endPublish(span trace.Span, delivery models.Delivery, err error) {
+	if err == nil {
+		span.SetAttributes(
+			attribute.Int("messaging.kafka.destination.partition", delivery.Partition),
+			attribute.Int64("messaging.kafka.message.offset", delivery.Offset),
+		)
+	}
+	tracing.RecordError(span, err)
+	span.End()
+}
+
+// tracedDelivery ends the producer span before passing the result to done
+func tracedDelivery(span trace.Span, done DeliveryFunc) DeliveryFunc {
+	return func(delivery models.Delivery, err error) {
+		endPublish(span, delivery, err)
+		done(delivery, err)
+	}
+}
@@ -41,6 +41,10 @@ func (l Logger) Warn(msg string, fields ...zapcore.Field) {
 	l.writer().Warn(msg, fields...)
 }
 
+func (l Logger) Error(msg string, fields ...zapcore.Field) {
+	l.writer().Error(msg, fields...)
+}
+
 func (l Logger) writer() *zap.Logger {
 	noOpLogger := zap.NewNop()
 	if l.zap == nil {
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gophstream_http_requests_total",
+		Help: "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gophstream_http_request_duration_seconds",
+		Help:    "Time to serve HTTP requests, by route and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+	httpInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gophstream_http_requests_in_flight",
+		Help: "HTTP requests being served.",
+	})
+)
+
+// statusWriter records the status code of the response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(statusCode int) {
+	if sw.status == 0 {
+		sw.status = statusCode
+	}
+	sw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(p)
+}
+
+// Flush keeps streamed responses working through the wrapper
+func (sw *statusWriter) Flush() {
+	_ = http.NewResponseController(sw.ResponseWriter).Flush()
+}
+
+// Unwrap returns the original http.ResponseWriter for http.ResponseController
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// Metrics — middleware counting and timing HTTP requests.
+// Requests are labelled with the route pattern rather than the path,
+// so that IDs in the path do not create a series each.
+func Metrics(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+
+		httpInFlight.Inc()
+		defer httpInFlight.Dec()
+
+		h.ServeHTTP(sw, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+
+		httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(sw.status)).Inc()
+		httpDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
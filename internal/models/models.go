@@ -1,27 +1,411 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
 
 // RequestMessage represents the incoming message data from the client
 type RequestMessage struct {
 	Content string `json:"content"`
+	// Topic is only matched by the routing rules, it does not choose the Kafka topic
+	Topic string `json:"topic,omitempty"`
+	Type  string `json:"type,omitempty"`
+	// Key is the partition key, messages with the same key keep their order
+	Key     string            `json:"key,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// IdempotencyRecord represents the stored outcome of a request made with an Idempotency-Key.
+// A replay with the same key and request hash gets the stored response back.
+type IdempotencyRecord struct {
+	Key         string
+	RequestHash string
+	MessageID   int
+	StatusCode  int
+	Response    []byte
+	ExpiresAt   time.Time
+}
+
+// BatchItemResult represents the outcome of one item of a batch of messages,
+// either the ID of the created message or the reason it was rejected
+type BatchItemResult struct {
+	Index int    `json:"index"`
+	ID    int    `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// BatchResult represents the response to a batch of messages
+type BatchResult struct {
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Results   []BatchItemResult `json:"results"`
+}
+
+// MessageStatus represents the delivery status of a message
+type MessageStatus string
+
+const (
+	// StatusQueued marks a message waiting to be sent to Kafka
+	StatusQueued MessageStatus = "queued"
+	// StatusInFlight marks a message claimed by a relay and being sent
+	StatusInFlight MessageStatus = "in_flight"
+	// StatusSent marks a message acknowledged by Kafka
+	StatusSent MessageStatus = "sent"
+	// StatusFailed marks a message whose last attempt failed and which will be retried
+	StatusFailed MessageStatus = "failed"
+	// StatusDead marks a message that will not be retried
+	StatusDead MessageStatus = "dead"
+)
+
+// messageTransitions lists for every status the statuses a message may move into it from
+var messageTransitions = map[MessageStatus][]MessageStatus{
+	StatusQueued:   {StatusFailed, StatusDead},
+	StatusInFlight: {StatusQueued, StatusFailed, StatusInFlight},
+	StatusSent:     {StatusInFlight},
+	StatusFailed:   {StatusInFlight},
+	StatusDead:     {StatusInFlight, StatusFailed},
+}
+
+// ParseMessageStatus converts a string to a MessageStatus
+func ParseMessageStatus(s string) (MessageStatus, error) {
+	status := MessageStatus(s)
+	if _, ok := messageTransitions[status]; !ok {
+		return "", fmt.Errorf("unknown message status %q", s)
+	}
+
+	return status, nil
+}
+
+// AllowedFrom returns the statuses a message may transition to s from
+func (s MessageStatus) AllowedFrom() []MessageStatus {
+	return messageTransitions[s]
+}
+
+// CanTransition reports whether a message may move from one status to another
+func CanTransition(from, to MessageStatus) bool {
+	for _, s := range messageTransitions[to] {
+		if s == from {
+			return true
+		}
+	}
+
+	return false
 }
 
 // Message represents the message stored in the database and processed through Kafka
 type Message struct {
-	ID        int       `json:"id"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
-	Processed bool      `json:"processed"`
+	ID             int               `json:"id"`
+	Content        string            `json:"content"`
+	Topic          string            `json:"topic,omitempty"`
+	Type           string            `json:"type,omitempty"`
+	Key            string            `json:"key,omitempty"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	CreatedAt      time.Time         `json:"created_at"`
+	Status         MessageStatus     `json:"status"`
+	Attempts       int               `json:"attempts"`
+	LastError      string            `json:"last_error,omitempty"`
+	ErrorClass     ErrorClass        `json:"error_class,omitempty"`
+	SentAt         *time.Time        `json:"sent_at,omitempty"`
+	KafkaPartition *int              `json:"kafka_partition,omitempty"`
+	KafkaOffset    *int64            `json:"kafka_offset,omitempty"`
+	// TraceParent is the trace context of the request that created the message
+	TraceParent string `json:"trace_parent,omitempty"`
+}
+
+// ErrorClass classifies an error returned while publishing a message
+type ErrorClass string
+
+const (
+	// ErrorClassTimeout is a request or context deadline
+	ErrorClassTimeout ErrorClass = "timeout"
+	// ErrorClassNetwork is a connection level failure
+	ErrorClassNetwork ErrorClass = "network"
+	// ErrorClassBroker is a temporary error reported by a broker
+	ErrorClassBroker ErrorClass = "broker"
+	// ErrorClassMessage is a message that the broker will never accept
+	ErrorClassMessage ErrorClass = "message"
+	// ErrorClassAuth is an authentication or authorization failure
+	ErrorClassAuth ErrorClass = "auth"
+	// ErrorClassConfig is a permanent error caused by the topic or client configuration
+	ErrorClassConfig ErrorClass = "config"
+	// ErrorClassUnknown is any other error
+	ErrorClassUnknown ErrorClass = "unknown"
+	// ErrorClassShutdown is an attempt whose outcome was not known when the relay shut down
+	ErrorClassShutdown ErrorClass = "shutdown"
+)
+
+// Transition represents a requested change of the delivery status of a message
+type Transition struct {
+	ID         int
+	To         MessageStatus
+	Error      string
+	ErrorClass ErrorClass
+	Partition  *int
+	Offset     *int64
+}
+
+// MessageEvent represents a change of the delivery status of a message published to the live feed
+type MessageEvent struct {
+	ID             int64         `json:"id"`
+	MessageID      int           `json:"message_id"`
+	Status         MessageStatus `json:"status"`
+	Error          string        `json:"error,omitempty"`
+	ErrorClass     ErrorClass    `json:"error_class,omitempty"`
+	KafkaPartition *int          `json:"kafka_partition,omitempty"`
+	KafkaOffset    *int64        `json:"kafka_offset,omitempty"`
+	CreatedAt      time.Time     `json:"created_at"`
+}
+
+// StatsBucket is the width of the throughput buckets of the statistics
+type StatsBucket string
+
+const (
+	StatsBucketMinute StatsBucket = "minute"
+	StatsBucketHour   StatsBucket = "hour"
+)
+
+// Duration returns the width of the bucket
+func (b StatsBucket) Duration() time.Duration {
+	if b == StatsBucketHour {
+		return time.Hour
+	}
+
+	return time.Minute
+}
+
+// StatsQuery represents the window the statistics are computed over
+type StatsQuery struct {
+	Window time.Duration
+	Bucket StatsBucket
+}
+
+// ThroughputPoint represents the number of messages sent within one bucket
+type ThroughputPoint struct {
+	Time time.Time `json:"time"`
+	Sent int64     `json:"sent"`
+}
+
+// Stats represents the statistics of processed messages. Totals cover all messages,
+// throughput and latency the messages sent within the window.
+type Stats struct {
+	Window            string                  `json:"window"`
+	Bucket            StatsBucket             `json:"bucket"`
+	Total             int64                   `json:"total"`
+	ByStatus          map[MessageStatus]int64 `json:"by_status"`
+	Sent              int64                   `json:"sent"`
+	Throughput        []ThroughputPoint       `json:"throughput"`
+	AvgLatencySeconds float64                 `json:"avg_latency_seconds"`
+	P95LatencySeconds float64                 `json:"p95_latency_seconds"`
+	FailuresByClass   map[ErrorClass]int64    `json:"failures_by_class"`
+	GeneratedAt       time.Time               `json:"generated_at"`
+}
+
+// SchemaVersion represents the migration version of the database
+type SchemaVersion struct {
+	Version  uint `json:"version"`
+	Expected uint `json:"expected"`
+	Dirty    bool `json:"dirty"`
 }
 
-// Filter represents the criteria for filtering messages
+// ComponentHealth represents the result of checking one dependency
+type ComponentHealth struct {
+	Status  string         `json:"status"`
+	Error   string         `json:"error,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// Health represents the readiness of the service and of each of its dependencies
+type Health struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentHealth `json:"components,omitempty"`
+}
+
+// Filter represents the criteria for filtering messages, empty criteria match all messages
 type Filter struct {
-	Processed *bool `json:"processed"`
+	Statuses    []MessageStatus   `json:"status"`
+	CreatedFrom *time.Time        `json:"created_from"`
+	CreatedTo   *time.Time        `json:"created_to"`
+	MinID       *int              `json:"min_id"`
+	MaxID       *int              `json:"max_id"`
+	Contains    string            `json:"q"`
+	Search      string            `json:"search"`
+	Topic       string            `json:"topic"`
+	Type        string            `json:"type"`
+	Headers     map[string]string `json:"headers"`
+}
+
+// SortField is a field messages can be ordered by
+type SortField string
+
+const (
+	SortByID        SortField = "id"
+	SortByCreatedAt SortField = "created_at"
+	SortBySentAt    SortField = "sent_at"
+	SortByStatus    SortField = "status"
+	SortByAttempts  SortField = "attempts"
+)
+
+// ParseSortField converts a string to a SortField
+func ParseSortField(s string) (SortField, error) {
+	switch f := SortField(s); f {
+	case SortByID, SortByCreatedAt, SortBySentAt, SortByStatus, SortByAttempts:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown sort field %q", s)
+	}
+}
+
+// Sort represents the order of listed messages, ties are broken by ID
+type Sort struct {
+	Field SortField `json:"sort"`
+	Desc  bool      `json:"desc"`
 }
 
-// Pagination represents pagination details for listing messages
+// RedriveFilter represents the criteria for selecting failed and dead-lettered messages to send again
+type RedriveFilter struct {
+	MessageID     *int            `json:"-"`
+	From          *time.Time      `json:"from"`
+	To            *time.Time      `json:"to"`
+	Statuses      []MessageStatus `json:"status"`
+	ErrorContains string          `json:"error_contains"`
+}
+
+// Pagination represents pagination details for listing messages.
+// In keyset mode messages are ordered by (created_at, id) and the page
+// starts after the After cursor instead of skipping Offset messages.
 type Pagination struct {
-	Limit  int `json:"limit"`
-	Offset int `json:"offset"`
+	Limit  int     `json:"limit"`
+	Offset int     `json:"offset"`
+	Keyset bool    `json:"-"`
+	After  *Cursor `json:"-"`
+}
+
+// Cursor represents the position of the last message of a page in keyset mode
+type Cursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        int       `json:"id"`
+	Desc      bool      `json:"d,omitempty"`
+}
+
+// MessagePage represents a page of messages listed in keyset mode
+type MessagePage struct {
+	Messages   []Message `json:"messages"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// OutboxState represents the delivery state of an outbox record
+type OutboxState string
+
+const (
+	// OutboxPending marks a record that is waiting to be claimed by a relay
+	OutboxPending OutboxState = "pending"
+	// OutboxClaimed marks a record leased by a relay until LeaseUntil
+	OutboxClaimed OutboxState = "claimed"
+	// OutboxDelivered marks a record acknowledged by Kafka
+	OutboxDelivered OutboxState = "delivered"
+	// OutboxDead marks a record published to the dead-letter topic
+	OutboxDead OutboxState = "dead"
+)
+
+// OutboxRecord represents a message queued for delivery to Kafka
+type OutboxRecord struct {
+	ID          int64       `json:"id"`
+	MessageID   int         `json:"message_id"`
+	Payload     []byte      `json:"payload"`
+	State       OutboxState `json:"state"`
+	Attempts    int         `json:"attempts"`
+	AvailableAt time.Time   `json:"available_at"`
+	LastError   string      `json:"last_error,omitempty"`
+	ClaimedBy   string      `json:"claimed_by,omitempty"`
+	LeaseUntil  *time.Time  `json:"lease_until,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+	DeliveredAt *time.Time  `json:"delivered_at,omitempty"`
+}
+
+// Delivery describes an outbox record acknowledged by Kafka and where it was written
+type Delivery struct {
+	OutboxID  int64
+	Topic     string
+	Partition int
+	Offset    int64
+}
+
+// DeliveryFailure describes a failed attempt to publish an outbox record.
+// The record is retried at RetryAt unless Dead is set.
+type DeliveryFailure struct {
+	OutboxID int64
+	Error    string
+	Class    ErrorClass
+	Dead     bool
+	RetryAt  time.Time
+}
+
+// HeaderMessageID is the Kafka header carrying the ID of the message a record was written for
+const HeaderMessageID = "gophstream-message-id"
+
+// OutgoingMessage represents a record written to a Kafka topic.
+// A nil Key lets the producer balance records over the partitions.
+type OutgoingMessage struct {
+	Topic   string
+	Key     []byte
+	Headers map[string]string
+	Value   []byte
+}
+
+// DeadLetter represents a message published to the dead-letter topic
+type DeadLetter struct {
+	OutboxID   int64           `json:"outbox_id"`
+	MessageID  int             `json:"message_id"`
+	Attempts   int             `json:"attempts"`
+	Error      string          `json:"error"`
+	ErrorClass ErrorClass      `json:"error_class"`
+	FailedAt   time.Time       `json:"failed_at"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// Receipt represents the confirmation that a message was read back from Kafka
+type Receipt struct {
+	ID            int64     `json:"id"`
+	MessageID     int       `json:"message_id"`
+	Topic         string    `json:"topic"`
+	Partition     int       `json:"partition"`
+	Offset        int64     `json:"offset"`
+	ConsumerGroup string    `json:"consumer_group"`
+	ConsumedAt    time.Time `json:"consumed_at"`
+}
+
+// ConsumerStats represents the delivery counters of the Kafka consumer
+type ConsumerStats struct {
+	Topics        []string `json:"topics"`
+	ConsumerGroup string   `json:"consumer_group"`
+	Lag           int64    `json:"lag"`
+	Consumed      int64    `json:"consumed"`
+	Receipts      int64    `json:"receipts"`
+	Duplicates    int64    `json:"duplicates"`
+	Failures      int64    `json:"failures"`
+}
+
+// JobState represents the state of a background job
+type JobState string
+
+const (
+	// JobRunning marks a job with tasks still queued in the worker pool
+	JobRunning JobState = "running"
+	// JobCompleted marks a job whose tasks have all finished
+	JobCompleted JobState = "completed"
+)
+
+// Job represents the progress of a background job processed by the worker pool
+type Job struct {
+	ID         string     `json:"id"`
+	Kind       string     `json:"kind"`
+	State      JobState   `json:"state"`
+	Total      int        `json:"total"`
+	Succeeded  int        `json:"succeeded"`
+	Failed     int        `json:"failed"`
+	Errors     []string   `json:"errors,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
 }
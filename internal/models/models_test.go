@@ -0,0 +1,61 @@
+package models
+
+import "testing"
+
+func TestCanTransition(t *testing.T) {
+	statuses := []MessageStatus{StatusQueued, StatusInFlight, StatusSent, StatusFailed, StatusDead}
+
+	// legal lists every edge of the delivery state machine
+	legal := map[[2]MessageStatus]bool{
+		{StatusQueued, StatusInFlight}:   true, // claimed by a relay
+		{StatusFailed, StatusInFlight}:   true, // claimed again for a retry
+		{StatusInFlight, StatusInFlight}: true, // lease expired and claimed again
+		{StatusInFlight, StatusSent}:     true, // acknowledged by Kafka
+		{StatusInFlight, StatusFailed}:   true, // attempt failed, or released on shutdown
+		{StatusInFlight, StatusDead}:     true, // attempts exhausted or permanent error
+		{StatusFailed, StatusDead}:       true, // dead-lettered without a new attempt
+		{StatusFailed, StatusQueued}:     true, // redriven
+		{StatusDead, StatusQueued}:       true, // redriven
+	}
+
+	for _, from := range statuses {
+		for _, to := range statuses {
+			want := legal[[2]MessageStatus{from, to}]
+			t.Run(string(from)+"->"+string(to), func(t *testing.T) {
+				if got := CanTransition(from, to); got != want {
+					t.Errorf("CanTransition(%s, %s) = %v, want %v", from, to, got, want)
+				}
+			})
+		}
+	}
+
+	if CanTransition(StatusQueued, "unknown") || CanTransition("unknown", StatusSent) {
+		t.Error("CanTransition() allows a transition with an unknown status")
+	}
+}
+
+func TestParseMessageStatus(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    MessageStatus
+		wantErr bool
+	}{
+		{value: "queued", want: StatusQueued},
+		{value: "in_flight", want: StatusInFlight},
+		{value: "sent", want: StatusSent},
+		{value: "failed", want: StatusFailed},
+		{value: "dead", want: StatusDead},
+		{value: "", wantErr: true},
+		{value: "SENT", wantErr: true},
+		{value: "delivered", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseMessageStatus(tt.value)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParseMessageStatus(%q) = %q, %v, want %q, error %v", tt.value, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
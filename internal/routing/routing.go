@@ -0,0 +1,192 @@
+package routing
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultRule is the name reported when no rule matches a message
+const DefaultRule = "default"
+
+// headerKeyPrefix selects the partition key from a message header
+const headerKeyPrefix = "header:"
+
+// Match holds the conditions of a rule, empty conditions match any message
+type Match struct {
+	Type          string            `yaml:"type" json:"type,omitempty"`
+	Topic         string            `yaml:"topic" json:"topic,omitempty"`
+	ContentPrefix string            `yaml:"content_prefix" json:"content_prefix,omitempty"`
+	Headers       map[string]string `yaml:"headers" json:"headers,omitempty"`
+}
+
+// Rule sends the messages it matches to Topic.
+// Key names the message field used as the partition key: id, type, topic or header:<name>.
+type Rule struct {
+	Name  string `yaml:"name" json:"name"`
+	Match Match  `yaml:"match" json:"match"`
+	Topic string `yaml:"topic" json:"topic"`
+	Key   string `yaml:"key" json:"key,omitempty"`
+}
+
+// Input holds the message fields rules are matched against.
+// Topic is the topic given by the client, a message is only sent there by a
+// rule that routes it there, otherwise the consumer would not read it back.
+type Input struct {
+	ID      int               `json:"id"`
+	Type    string            `json:"type"`
+	Topic   string            `json:"topic"`
+	Content string            `json:"content"`
+	Headers map[string]string `json:"headers"`
+}
+
+// Route is the destination chosen for a message
+type Route struct {
+	Rule    string `json:"rule"`
+	Matched bool   `json:"matched"`
+	Topic   string `json:"topic"`
+	Key     string `json:"key,omitempty"`
+}
+
+// Router picks the topic and partition key of a message from an ordered list of rules
+type Router struct {
+	rules        []Rule
+	defaultTopic func() string
+}
+
+// NewRouter creates a new Router instance. Messages that match no rule
+// are sent to the default topic.
+func NewRouter(rules []Rule, defaultTopic func() string) *Router {
+	return &Router{
+		rules:        rules,
+		defaultTopic: defaultTopic,
+	}
+}
+
+// Route returns the destination of the first rule matching the message
+func (r *Router) Route(in Input) Route {
+	for _, rule := range r.rules {
+		if rule.matches(in) {
+			return Route{
+				Rule:    rule.Name,
+				Matched: true,
+				Topic:   rule.Topic,
+				Key:     rule.key(in),
+			}
+		}
+	}
+
+	return Route{
+		Rule:  DefaultRule,
+		Topic: r.defaultTopic(),
+	}
+}
+
+// Rules returns the configured rules in matching order
+func (r *Router) Rules() []Rule {
+	return r.rules
+}
+
+// Topics returns the default topic followed by the topics of the rules,
+// each topic once, i.e. every topic a message can be routed to
+func (r *Router) Topics() []string {
+	topics := []string{r.defaultTopic()}
+	seen := map[string]bool{topics[0]: true}
+	for _, rule := range r.rules {
+		if !seen[rule.Topic] {
+			seen[rule.Topic] = true
+			topics = append(topics, rule.Topic)
+		}
+	}
+
+	return topics
+}
+
+func (rule Rule) matches(in Input) bool {
+	m := rule.Match
+	if m.Type != "" && m.Type != in.Type {
+		return false
+	}
+	if m.Topic != "" && m.Topic != in.Topic {
+		return false
+	}
+	if m.ContentPrefix != "" && !strings.HasPrefix(in.Content, m.ContentPrefix) {
+		return false
+	}
+	for name, value := range m.Headers {
+		if v, ok := in.Headers[name]; !ok || v != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (rule Rule) key(in Input) string {
+	switch {
+	case rule.Key == "id":
+		return strconv.Itoa(in.ID)
+	case rule.Key == "type":
+		return in.Type
+	case rule.Key == "topic":
+		return in.Topic
+	case strings.HasPrefix(rule.Key, headerKeyPrefix):
+		return in.Headers[strings.TrimPrefix(rule.Key, headerKeyPrefix)]
+	default:
+		return ""
+	}
+}
+
+// LoadRules reads rules from a YAML or JSON file of the form
+//
+//	rules:
+//	  - name: billing
+//	    match: {type: invoice}
+//	    topic: billing
+//	    key: header:tenant
+//
+// An empty path means no rules.
+func LoadRules(path string) ([]Rule, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read routing rules: %w", err)
+	}
+
+	// YAML is a superset of JSON, so one decoder handles both formats
+	var file struct {
+		Rules []Rule `yaml:"rules"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("cannot parse routing rules %s: %w", path, err)
+	}
+
+	names := make(map[string]bool, len(file.Rules))
+	for i, rule := range file.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("routing rule #%d has no name", i+1)
+		}
+		if names[rule.Name] || rule.Name == DefaultRule {
+			return nil, fmt.Errorf("routing rule name %q is not unique", rule.Name)
+		}
+		names[rule.Name] = true
+
+		if rule.Topic == "" {
+			return nil, fmt.Errorf("routing rule %q has no topic", rule.Name)
+		}
+		switch {
+		case rule.Key == "", rule.Key == "id", rule.Key == "type", rule.Key == "topic":
+		case strings.HasPrefix(rule.Key, headerKeyPrefix) && len(rule.Key) > len(headerKeyPrefix):
+		default:
+			return nil, fmt.Errorf("routing rule %q has unknown key %q", rule.Name, rule.Key)
+		}
+	}
+
+	return file.Rules, nil
+}
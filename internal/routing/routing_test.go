@@ -0,0 +1,179 @@
+package routing
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRouterTopics(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []Rule
+		want  []string
+	}{
+		{name: "no rules", want: []string{"messages"}},
+		{
+			name: "rule topics in order, each once",
+			rules: []Rule{
+				{Name: "orders", Topic: "orders"},
+				{Name: "default again", Topic: "messages"},
+				{Name: "audit", Topic: "audit"},
+				{Name: "more orders", Topic: "orders"},
+			},
+			want: []string{"messages", "orders", "audit"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRouter(tt.rules, func() string { return "messages" })
+			if got := r.Topics(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Topics() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouterRoute(t *testing.T) {
+	rules := []Rule{
+		{Name: "vip invoices", Match: Match{Type: "invoice", Headers: map[string]string{"tier": "vip"}}, Topic: "billing-vip", Key: "header:tenant"},
+		{Name: "invoices", Match: Match{Type: "invoice"}, Topic: "billing", Key: "id"},
+		{Name: "audit topic", Match: Match{Topic: "audit"}, Topic: "audit", Key: "topic"},
+		{Name: "alerts", Match: Match{ContentPrefix: "ALERT:"}, Topic: "alerts", Key: "type"},
+		{Name: "no key", Match: Match{Type: "ping"}, Topic: "pings"},
+	}
+	r := NewRouter(rules, func() string { return "messages" })
+
+	tests := []struct {
+		name string
+		in   Input
+		want Route
+	}{
+		{
+			name: "first matching rule wins",
+			in:   Input{ID: 7, Type: "invoice", Headers: map[string]string{"tier": "vip", "tenant": "acme"}},
+			want: Route{Rule: "vip invoices", Matched: true, Topic: "billing-vip", Key: "acme"},
+		},
+		{
+			name: "header value must be equal",
+			in:   Input{ID: 7, Type: "invoice", Headers: map[string]string{"tier": "basic"}},
+			want: Route{Rule: "invoices", Matched: true, Topic: "billing", Key: "7"},
+		},
+		{
+			name: "missing header key is empty",
+			in:   Input{ID: 8, Type: "invoice", Headers: map[string]string{"tier": "vip"}},
+			want: Route{Rule: "vip invoices", Matched: true, Topic: "billing-vip"},
+		},
+		{
+			name: "topic match",
+			in:   Input{ID: 9, Topic: "audit"},
+			want: Route{Rule: "audit topic", Matched: true, Topic: "audit", Key: "audit"},
+		},
+		{
+			name: "content prefix",
+			in:   Input{ID: 10, Type: "disk", Content: "ALERT: disk full"},
+			want: Route{Rule: "alerts", Matched: true, Topic: "alerts", Key: "disk"},
+		},
+		{
+			name: "content prefix is case sensitive",
+			in:   Input{ID: 11, Content: "alert: disk full"},
+			want: Route{Rule: DefaultRule, Topic: "messages"},
+		},
+		{
+			name: "rule without key",
+			in:   Input{ID: 12, Type: "ping"},
+			want: Route{Rule: "no key", Matched: true, Topic: "pings"},
+		},
+		{
+			name: "unmatched topic falls back to the default",
+			in:   Input{ID: 13, Topic: "elsewhere"},
+			want: Route{Rule: DefaultRule, Topic: "messages"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.Route(tt.in); got != tt.want {
+				t.Errorf("Route() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []Rule
+		wantErr string
+	}{
+		{
+			name: "yaml",
+			data: `
+rules:
+  - name: billing
+    match: {type: invoice, headers: {tier: vip}}
+    topic: billing
+    key: header:tenant
+  - name: alerts
+    match: {content_prefix: "ALERT:"}
+    topic: alerts
+`,
+			want: []Rule{
+				{Name: "billing", Match: Match{Type: "invoice", Headers: map[string]string{"tier": "vip"}}, Topic: "billing", Key: "header:tenant"},
+				{Name: "alerts", Match: Match{ContentPrefix: "ALERT:"}, Topic: "alerts"},
+			},
+		},
+		{
+			name: "json",
+			data: `{"rules": [{"name": "audit", "match": {"topic": "audit"}, "topic": "audit", "key": "id"}]}`,
+			want: []Rule{{Name: "audit", Match: Match{Topic: "audit"}, Topic: "audit", Key: "id"}},
+		},
+		{name: "no rules", data: `rules: []`, want: []Rule{}},
+		{name: "malformed", data: `rules: [`, wantErr: "cannot parse routing rules"},
+		{name: "no name", data: `rules: [{topic: a}]`, wantErr: "has no name"},
+		{name: "duplicate name", data: `rules: [{name: a, topic: a}, {name: a, topic: b}]`, wantErr: "not unique"},
+		{name: "default name", data: `rules: [{name: default, topic: a}]`, wantErr: "not unique"},
+		{name: "no topic", data: `rules: [{name: a}]`, wantErr: "has no topic"},
+		{name: "unknown key", data: `rules: [{name: a, topic: a, key: content}]`, wantErr: "unknown key"},
+		{name: "empty header key", data: `rules: [{name: a, topic: a, key: "header:"}]`, wantErr: "unknown key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rules.yml")
+			if err := os.WriteFile(path, []byte(tt.data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := LoadRules(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadRules() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadRules() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadRules() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("empty path", func(t *testing.T) {
+		if rules, err := LoadRules(""); rules != nil || err != nil {
+			t.Errorf("LoadRules(\"\") = %v, %v, want no rules", rules, err)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := LoadRules(filepath.Join(t.TempDir(), "missing.yml")); err == nil {
+			t.Error("LoadRules() of a missing file succeeded")
+		}
+	})
+}
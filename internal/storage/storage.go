@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"sync"
+	"time"
 
 	"github.com/wurt83ow/gophstream/internal/models"
 	"go.uber.org/zap"
@@ -15,107 +16,288 @@ var (
 	ErrNotFound = errors.New("not found")
 )
 
-type (
-	StorageMessage = map[int]models.Message
-)
+// statsTTL is how long computed statistics are served from memory
+const statsTTL = 10 * time.Second
+
+// cachedStats represents statistics together with the time they expire
+type cachedStats struct {
+	stats     models.Stats
+	expiresAt time.Time
+}
 
 type Log interface {
 	Info(string, ...zap.Field)
 }
 
-// MemoryStorage represents an in-memory storage with locking mechanisms
+// MemoryStorage represents a storage backed by the database that keeps computed statistics in memory
 type MemoryStorage struct {
-	ctx      context.Context
-	mx       sync.RWMutex
-	messages StorageMessage
-	keeper   Keeper
-	log      Log
+	ctx     context.Context
+	statsMx sync.Mutex
+	stats   map[models.StatsQuery]cachedStats
+	keeper  Keeper
+	log     Log
 }
 
 // Keeper interface for database operations
 type Keeper interface {
-	LoadMessages(context.Context) (StorageMessage, error)
-	InsertMessage(context.Context, models.Message) (int, error)
-	GetMessages(context.Context, models.Filter, models.Pagination) ([]models.Message, error)
-	UpdateMessagesProcessed(ctx context.Context, ids []int) error
-	Ping(context.Context) bool
+	InsertMessage(context.Context, models.Message, *models.IdempotencyRecord) (int, *models.IdempotencyRecord, error)
+	InsertMessages(context.Context, []models.Message) ([]int, error)
+	GetMessage(context.Context, int) (models.Message, bool, error)
+	GetMessages(context.Context, models.Filter, models.Sort, models.Pagination) ([]models.Message, error)
+	StreamMessages(context.Context, models.Filter, models.Sort, func(models.Message) error) error
+	ListenMessageEvents(context.Context, func(models.MessageEvent)) error
+	GetMessageEvents(ctx context.Context, afterID int64, limit int) ([]models.MessageEvent, error)
+	PurgeMessageEvents(ctx context.Context, before time.Time) (int64, error)
+	GetStats(context.Context, models.StatsQuery) (models.Stats, error)
+	ClaimOutbox(ctx context.Context, owner string, limit int, lease time.Duration) ([]models.OutboxRecord, error)
+	ClaimAbandonedOutbox(ctx context.Context, owner string, limit int, lease time.Duration) ([]models.OutboxRecord, error)
+	MarkOutboxDelivered(ctx context.Context, owner string, deliveries []models.Delivery) error
+	FailOutbox(ctx context.Context, owner string, failures []models.DeliveryFailure) error
+	ReleaseOutbox(ctx context.Context, owner, reason string) (int, error)
+	InsertReceipt(context.Context, models.Receipt) (bool, error)
+	FindRedriveCandidates(context.Context, models.RedriveFilter, int) ([]int, error)
+	RedriveMessage(context.Context, int) (bool, error)
+	Ping(context.Context) error
+	SchemaVersion(context.Context) (models.SchemaVersion, error)
 	Close() bool
 }
 
 // NewMemoryStorage creates a new MemoryStorage instance
 func NewMemoryStorage(ctx context.Context, keeper Keeper, log Log) *MemoryStorage {
-	messages := make(StorageMessage)
-
-	if keeper != nil {
-		var err error
-		// Load messages
-		messages, err = keeper.LoadMessages(ctx)
-		if err != nil {
-			log.Info("cannot load user data: ", zap.Error(err))
-		}
-	}
-
 	return &MemoryStorage{
-		ctx:      ctx,
-		messages: messages,
-		keeper:   keeper,
-		log:      log,
+		ctx:    ctx,
+		stats:  make(map[models.StatsQuery]cachedStats),
+		keeper: keeper,
+		log:    log,
 	}
 }
 
-// InsertMessage inserts a new message into the storage and database
-func (s *MemoryStorage) InsertMessage(ctx context.Context, message models.Message) error {
-	s.mx.Lock()
-	defer s.mx.Unlock()
-
+// InsertMessage inserts a new message into the storage and database and returns it with its ID.
+// If idem is set and its key was already used, nothing is inserted and the stored record is returned.
+func (s *MemoryStorage) InsertMessage(ctx context.Context, message models.Message,
+	idem *models.IdempotencyRecord,
+) (models.Message, *models.IdempotencyRecord, error) {
 	// Insert message into the database
-	id, err := s.keeper.InsertMessage(ctx, message)
+	id, stored, err := s.keeper.InsertMessage(ctx, message, idem)
 	if err != nil {
 		s.log.Info("error inserting message to database: ", zap.Error(err))
-		return err
+		return models.Message{}, nil, err
+	}
+	if stored != nil {
+		return models.Message{}, stored, nil
 	}
 
-	// Save to the in-memory map with the new ID
 	message.ID = id
-	s.messages[id] = message
 
-	return nil
+	return message, nil, nil
+}
+
+// InsertMessages inserts a batch of messages into the storage and database and returns them with their IDs
+func (s *MemoryStorage) InsertMessages(ctx context.Context, messages []models.Message) ([]models.Message, error) {
+	ids, err := s.keeper.InsertMessages(ctx, messages)
+	if err != nil {
+		s.log.Info("error inserting messages to database: ", zap.Error(err))
+		return nil, err
+	}
+
+	created := make([]models.Message, len(messages))
+	for i, message := range messages {
+		message.ID = ids[i]
+		created[i] = message
+	}
+
+	return created, nil
+}
+
+// GetMessage retrieves a message with its current delivery state from the database.
+// ErrNotFound is returned if there is no message with the ID.
+func (s *MemoryStorage) GetMessage(ctx context.Context, id int) (models.Message, error) {
+	message, ok, err := s.keeper.GetMessage(ctx, id)
+	if err != nil {
+		s.log.Info("error getting message from database: ", zap.Error(err))
+		return models.Message{}, err
+	}
+	if !ok {
+		return models.Message{}, ErrNotFound
+	}
+
+	return message, nil
 }
 
-// GetMessages retrieves processed messages from the database based on the provided filter and pagination
-func (s *MemoryStorage) GetMessages(ctx context.Context, filter models.Filter, pagination models.Pagination) ([]models.Message, error) {
+// GetMessages retrieves messages from the database based on the provided filter, sort and pagination
+func (s *MemoryStorage) GetMessages(ctx context.Context, filter models.Filter, sort models.Sort,
+	pagination models.Pagination,
+) ([]models.Message, error) {
 	// Get messages from the database
-	messages, err := s.keeper.GetMessages(ctx, filter, pagination)
+	messages, err := s.keeper.GetMessages(ctx, filter, sort, pagination)
 	if err != nil {
-		s.log.Info("error getting processed messages from database: ", zap.Error(err))
+		s.log.Info("error getting messages from database: ", zap.Error(err))
 		return nil, err
 	}
 
 	return messages, nil
 }
 
-// UpdateMessagesProcessed updates the processed status of messages in the database
-func (s *MemoryStorage) UpdateMessagesProcessed(ctx context.Context, ids []int) error {
-	// Update the messages' processed status in the database
-	err := s.keeper.UpdateMessagesProcessed(ctx, ids)
-	if err != nil {
-		s.log.Info("error updating messages processed status in database: ", zap.Error(err))
+// StreamMessages passes the messages matching the filter to fn one at a time straight from the database
+func (s *MemoryStorage) StreamMessages(ctx context.Context, filter models.Filter, sort models.Sort,
+	fn func(models.Message) error,
+) error {
+	if err := s.keeper.StreamMessages(ctx, filter, sort, fn); err != nil {
+		s.log.Info("error streaming messages from database: ", zap.Error(err))
 		return err
 	}
 
-	s.mx.Lock()
-	defer s.mx.Unlock()
+	return nil
+}
+
+// ListenMessageEvents passes the message events committed by any instance to fn until the context is done
+func (s *MemoryStorage) ListenMessageEvents(ctx context.Context, fn func(models.MessageEvent)) error {
+	return s.keeper.ListenMessageEvents(ctx, fn)
+}
+
+// GetMessageEvents returns up to limit message events recorded after the event afterID
+func (s *MemoryStorage) GetMessageEvents(ctx context.Context, afterID int64, limit int) ([]models.MessageEvent, error) {
+	events, err := s.keeper.GetMessageEvents(ctx, afterID, limit)
+	if err != nil {
+		s.log.Info("error getting message events from database: ", zap.Error(err))
+		return nil, err
+	}
+
+	return events, nil
+}
 
-	// Update the in-memory map if the messages exist
-	for _, id := range ids {
-		if message, exists := s.messages[id]; exists {
-			message.Processed = true
-			s.messages[id] = message
-		} else {
-			s.log.Info("message not found in memory", zap.Int("id", id))
-			return ErrNotFound
+// PurgeMessageEvents deletes the message events recorded before the given time
+func (s *MemoryStorage) PurgeMessageEvents(ctx context.Context, before time.Time) (int64, error) {
+	return s.keeper.PurgeMessageEvents(ctx, before)
+}
+
+// GetStats returns the statistics of processed messages. The statistics are computed by the
+// database at most once every statsTTL for each query, the requests in between get the cached ones.
+func (s *MemoryStorage) GetStats(ctx context.Context, query models.StatsQuery) (models.Stats, error) {
+	// holding the lock while computing keeps concurrent requests from running the same aggregates
+	s.statsMx.Lock()
+	defer s.statsMx.Unlock()
+
+	now := time.Now()
+	if cached, ok := s.stats[query]; ok && now.Before(cached.expiresAt) {
+		return cached.stats, nil
+	}
+
+	stats, err := s.keeper.GetStats(ctx, query)
+	if err != nil {
+		s.log.Info("error getting stats from database: ", zap.Error(err))
+		return models.Stats{}, err
+	}
+
+	// drop the expired entries, the queries of past requests are not bounded
+	for q, cached := range s.stats {
+		if !now.Before(cached.expiresAt) {
+			delete(s.stats, q)
 		}
 	}
+	s.stats[query] = cachedStats{stats: stats, expiresAt: now.Add(statsTTL)}
+
+	return stats, nil
+}
+
+// Ping checks that the database is reachable
+func (s *MemoryStorage) Ping(ctx context.Context) error {
+	return s.keeper.Ping(ctx)
+}
+
+// SchemaVersion returns the migration version of the database
+func (s *MemoryStorage) SchemaVersion(ctx context.Context) (models.SchemaVersion, error) {
+	return s.keeper.SchemaVersion(ctx)
+}
+
+// ClaimOutbox leases pending outbox records to the given relay owner
+func (s *MemoryStorage) ClaimOutbox(ctx context.Context, owner string, limit int, lease time.Duration) ([]models.OutboxRecord, error) {
+	records, err := s.keeper.ClaimOutbox(ctx, owner, limit, lease)
+	if err != nil {
+		s.log.Info("error claiming outbox records from database: ", zap.Error(err))
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// ClaimAbandonedOutbox takes over the outbox records left claimed by an earlier process of the relay
+func (s *MemoryStorage) ClaimAbandonedOutbox(ctx context.Context, owner string, limit int, lease time.Duration) ([]models.OutboxRecord, error) {
+	records, err := s.keeper.ClaimAbandonedOutbox(ctx, owner, limit, lease)
+	if err != nil {
+		s.log.Info("error claiming abandoned outbox records from database: ", zap.Error(err))
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// MarkOutboxDelivered marks acknowledged outbox records as delivered
+// and moves their messages to the sent status
+func (s *MemoryStorage) MarkOutboxDelivered(ctx context.Context, owner string, deliveries []models.Delivery) error {
+	if err := s.keeper.MarkOutboxDelivered(ctx, owner, deliveries); err != nil {
+		s.log.Info("error marking outbox records delivered in database: ", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// FailOutbox records failed deliveries, rescheduling or dead-lettering the outbox records
+func (s *MemoryStorage) FailOutbox(ctx context.Context, owner string, failures []models.DeliveryFailure) error {
+	if err := s.keeper.FailOutbox(ctx, owner, failures); err != nil {
+		s.log.Info("error recording outbox failures in database: ", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// ReleaseOutbox returns the records still claimed by owner to the relays
+// and marks their messages as failed, so that they are sent again
+func (s *MemoryStorage) ReleaseOutbox(ctx context.Context, owner, reason string) (int, error) {
+	released, err := s.keeper.ReleaseOutbox(ctx, owner, reason)
+	if err != nil {
+		s.log.Info("error releasing outbox records in database: ", zap.Error(err))
+		return 0, err
+	}
+
+	return released, nil
+}
+
+// InsertReceipt records a delivery receipt for a message consumed from Kafka
+func (s *MemoryStorage) InsertReceipt(ctx context.Context, receipt models.Receipt) (bool, error) {
+	inserted, err := s.keeper.InsertReceipt(ctx, receipt)
+	if err != nil {
+		s.log.Info("error inserting message receipt to database: ", zap.Error(err))
+		return false, err
+	}
+
+	return inserted, nil
+}
+
+// FindRedriveCandidates returns the IDs of failed and dead-lettered messages matching the filter
+func (s *MemoryStorage) FindRedriveCandidates(ctx context.Context, filter models.RedriveFilter, limit int) ([]int, error) {
+	ids, err := s.keeper.FindRedriveCandidates(ctx, filter, limit)
+	if err != nil {
+		s.log.Info("error finding messages to redrive in database: ", zap.Error(err))
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// RedriveMessage resets the delivery state of a message so that it is sent again.
+// ErrConflict is returned if the message is not failed or dead-lettered.
+func (s *MemoryStorage) RedriveMessage(ctx context.Context, id int) error {
+	ok, err := s.keeper.RedriveMessage(ctx, id)
+	if err != nil {
+		s.log.Info("error redriving message in database: ", zap.Error(err))
+		return err
+	}
+	if !ok {
+		return ErrConflict
+	}
 
 	return nil
 }
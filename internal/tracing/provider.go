@@ -0,0 +1,96 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Config selects the span exporter
+type Config struct {
+	// Exporter is none, otlp or stdout
+	Exporter string
+	// OTLPEndpoint is the OTLP/HTTP endpoint of the collector, e.g. http://localhost:4318
+	OTLPEndpoint string
+	// File is the file the stdout exporter appends to, stdout if empty or "-"
+	File        string
+	ServiceName string
+}
+
+// Provider records spans and exports them in batches in the background
+type Provider struct {
+	provider *sdktrace.TracerProvider
+	closer   io.Closer
+}
+
+// NewProvider creates a provider for the configured exporter and installs it
+// as the global tracer provider. It returns nil when tracing is disabled.
+func NewProvider(ctx context.Context, cfg Config) (*Provider, error) {
+	var (
+		exporter sdktrace.SpanExporter
+		closer   io.Closer
+		err      error
+	)
+
+	switch cfg.Exporter {
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(tracesURL(cfg.OTLPEndpoint)))
+	case "stdout":
+		var w io.Writer = os.Stdout
+		if cfg.File != "" && cfg.File != "-" {
+			f, ferr := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+			if ferr != nil {
+				return nil, fmt.Errorf("cannot open trace file: %w", ferr)
+			}
+			w, closer = f, f
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot create %s span exporter: %w", cfg.Exporter, err)
+	}
+
+	res := resource.NewSchemaless(attribute.String("service.name", cfg.ServiceName))
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+
+	return &Provider{provider: provider, closer: closer}, nil
+}
+
+// tracesURL appends the OTLP/HTTP traces path to a collector endpoint without a path
+func tracesURL(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || strings.Trim(u.Path, "/") != "" {
+		return endpoint
+	}
+	u.Path = "/v1/traces"
+
+	return u.String()
+}
+
+// Shutdown exports the spans that ended so far and closes the exporter
+func (p *Provider) Shutdown(ctx context.Context) error {
+	err := p.provider.Shutdown(ctx)
+	if p.closer != nil {
+		err = errors.Join(err, p.closer.Close())
+	}
+
+	return err
+}
@@ -0,0 +1,67 @@
+// Package tracing sets up OpenTelemetry tracing and propagates the context of
+// the spans recorded for a message in the W3C traceparent format, over HTTP,
+// the database and Kafka.
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TraceParentHeader is the HTTP and Kafka header carrying the trace context
+const TraceParentHeader = "traceparent"
+
+// instrumentationName is the name of the tracer the spans are recorded with
+const instrumentationName = "github.com/wurt83ow/gophstream"
+
+// propagator reads and writes the traceparent header
+var propagator = propagation.TraceContext{}
+
+// Tracer returns the tracer of the global provider installed by NewProvider.
+// Until then, or while tracing is disabled, it records nothing.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Propagator returns the propagator of the traceparent header
+func Propagator() propagation.TextMapPropagator {
+	return propagator
+}
+
+// ContextWithTraceParent returns a context continuing the trace of a traceparent
+// header value received from another process. Invalid values are ignored.
+func ContextWithTraceParent(ctx context.Context, traceParent string) context.Context {
+	if traceParent == "" {
+		return ctx
+	}
+
+	return propagator.Extract(ctx, propagation.MapCarrier{TraceParentHeader: traceParent})
+}
+
+// TraceParent returns the traceparent header value of the span in ctx,
+// or of the remote span if there is none
+func TraceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+
+	return carrier.Get(TraceParentHeader)
+}
+
+// HasSpan reports whether ctx holds a span, local or remote, to continue
+func HasSpan(ctx context.Context) bool {
+	return trace.SpanContextFromContext(ctx).IsValid()
+}
+
+// RecordError marks the span as failed with the error, a nil error is ignored
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,54 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTraceParent(t *testing.T) {
+	tests := []struct {
+		name        string
+		traceParent string
+		want        string
+	}{
+		{
+			name:        "valid",
+			traceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			want:        "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+		{name: "empty"},
+		{name: "invalid", traceParent: "00-not-a-trace-01"},
+		{name: "zero trace ID", traceParent: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := ContextWithTraceParent(context.Background(), tt.traceParent)
+			if got := TraceParent(ctx); got != tt.want {
+				t.Errorf("TraceParent() = %q, want %q", got, tt.want)
+			}
+			if HasSpan(ctx) != (tt.want != "") {
+				t.Errorf("HasSpan() = %v, want %v", HasSpan(ctx), tt.want != "")
+			}
+		})
+	}
+}
+
+func TestTracesURL(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+	}{
+		{endpoint: "http://localhost:4318", want: "http://localhost:4318/v1/traces"},
+		{endpoint: "http://localhost:4318/", want: "http://localhost:4318/v1/traces"},
+		{endpoint: "https://collector:4318/custom/traces", want: "https://collector:4318/custom/traces"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			if got := tracesURL(tt.endpoint); got != tt.want {
+				t.Errorf("tracesURL(%q) = %q, want %q", tt.endpoint, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package workerpool
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	queueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gophstream_workerpool_queue_depth",
+		Help: "Tasks waiting for a worker.",
+	})
+	queueLimit = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gophstream_workerpool_queue_capacity",
+		Help: "Tasks that can wait for a worker.",
+	})
+	activeWorkers = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gophstream_workerpool_active_workers",
+		Help: "Workers processing a task.",
+	})
+	taskDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "gophstream_workerpool_task_duration_seconds",
+		Help:    "Time to process a task.",
+		Buckets: prometheus.DefBuckets,
+	})
+	tasksProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gophstream_workerpool_tasks_total",
+		Help: "Processed tasks by result (ok, error, timeout, panic).",
+	}, []string{"result"})
+	tasksRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gophstream_workerpool_tasks_rejected_total",
+		Help: "Tasks not processed because the queue was full, by policy (block, reject, drop-oldest).",
+	}, []string{"policy"})
+)
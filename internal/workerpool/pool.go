@@ -1,6 +1,7 @@
 package workerpool
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"sync"
@@ -11,7 +12,9 @@ import (
 )
 
 type Log interface {
+	Debug(string, ...zapcore.Field)
 	Info(string, ...zapcore.Field)
+	Error(string, ...zapcore.Field)
 }
 
 // Pool.
@@ -25,10 +28,24 @@ type Pool struct {
 	wg            sync.WaitGroup
 	log           Log
 	taskInterval  int
+	taskTimeout   time.Duration
+	policy        QueuePolicy
+
+	// pending counts the tasks added with AddTask that are not processed yet,
+	// idle is signalled when it drops to zero
+	mx      sync.Mutex
+	idle    *sync.Cond
+	pending int
+
+	// ctx is cancelled by Stop, cancelling the running tasks
+	ctx        context.Context
+	cancelFunc context.CancelFunc
 }
 
 // NewPool initializes a new pool with the given tasks.
-func NewPool(tasks []*Task, concurrency func() string, log Log, TaskExecutionInterval func() string) *Pool {
+func NewPool(tasks []*Task, concurrency func() string, log Log, TaskExecutionInterval func() string, taskTimeout func() string,
+	queueCapacity func() string, queuePolicy func() string,
+) *Pool {
 	taskInterval, err := strconv.Atoi(TaskExecutionInterval())
 
 	if err != nil {
@@ -42,23 +59,52 @@ func NewPool(tasks []*Task, concurrency func() string, log Log, TaskExecutionInt
 		conc = 5
 	}
 
-	return &Pool{
+	timeout, err := time.ParseDuration(taskTimeout())
+	if err != nil || timeout <= 0 {
+		log.Info("cannot convert option 'TaskTimeout': ", zap.String("value", taskTimeout()), zap.Error(err))
+		timeout = 30 * time.Second
+	}
+
+	capacity, err := strconv.Atoi(queueCapacity())
+	if err != nil || capacity <= 0 {
+		log.Info("cannot convert option 'QueueCapacity': ", zap.String("value", queueCapacity()), zap.Error(err))
+		capacity = 1000
+	}
+
+	policy := QueuePolicy(queuePolicy())
+	if !policy.valid() {
+		log.Info("unknown queue policy, tasks are blocked while the queue is full", zap.String("policy", queuePolicy()))
+		policy = PolicyBlock
+	}
+	queueLimit.Set(float64(capacity))
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	p := &Pool{
 		Tasks:        tasks,
 		concurrency:  conc,
-		collector:    make(chan *Task, 1000),
+		collector:    make(chan *Task, capacity),
 		log:          log,
 		taskInterval: taskInterval,
+		taskTimeout:  timeout,
+		policy:       policy,
+		ctx:          ctx,
+		cancelFunc:   cancel,
 	}
+	p.idle = sync.NewCond(&p.mx)
+
+	return p
 }
 
 // Starts all the work in the Pool and blocks until it is finished.
 func (p *Pool) Run() {
 	for i := 1; i <= p.concurrency; i++ {
-		worker := NewWorker(p.collector, i)
+		worker := NewWorker(p, i)
 		worker.Start(&p.wg)
 	}
 
 	for i := range p.Tasks {
+		queueDepth.Inc()
 		p.collector <- p.Tasks[i]
 	}
 	close(p.collector)
@@ -66,9 +112,37 @@ func (p *Pool) Run() {
 	p.wg.Wait()
 }
 
-// AddTask adds tasks to the pool.
-func (p *Pool) AddTask(task *Task) {
-	p.collector <- task
+// Drain waits until the tasks added with AddTask have been processed.
+// It returns the error of ctx if the deadline passes first.
+func (p *Pool) Drain(ctx context.Context) error {
+	// wake the waiting goroutine when ctx is done, so that it does not outlive Drain
+	stop := context.AfterFunc(ctx, func() {
+		p.mx.Lock()
+		defer p.mx.Unlock()
+		p.idle.Broadcast()
+	})
+
+	drained := make(chan struct{})
+	go func() {
+		p.mx.Lock()
+		for p.pending > 0 && ctx.Err() == nil {
+			p.idle.Wait()
+		}
+		idle := p.pending == 0
+		p.mx.Unlock()
+
+		if idle {
+			close(drained)
+		}
+	}()
+
+	select {
+	case <-drained:
+		stop()
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d tasks still queued: %w", p.QueueDepth(), ctx.Err())
+	}
 }
 
 // RunBackground runs the pool in the background.
@@ -81,12 +155,13 @@ func (p *Pool) RunBackground() {
 	}()
 
 	for i := 1; i <= p.concurrency; i++ {
-		worker := NewWorker(p.collector, i)
+		worker := NewWorker(p, i)
 		p.Workers = append(p.Workers, worker)
 		go worker.StartBackground()
 	}
 
 	for i := range p.Tasks {
+		queueDepth.Inc()
 		p.collector <- p.Tasks[i]
 	}
 
@@ -94,14 +169,28 @@ func (p *Pool) RunBackground() {
 	<-p.runBackground
 }
 
-// Stop stops workers running in the background.
+// Stop stops workers running in the background and cancels the tasks they process.
 func (p *Pool) Stop() {
+	p.cancelFunc()
 	for i := range p.Workers {
 		p.Workers[i].Stop()
 	}
 
-	// p.cancelFunc()
-	// p.wg.Wait()
-
 	p.runBackground <- true
 }
+
+// taskAdded counts a task added with AddTask until taskDone is called for it
+func (p *Pool) taskAdded() {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	p.pending++
+}
+
+func (p *Pool) taskDone() {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	p.pending--
+	if p.pending == 0 {
+		p.idle.Broadcast()
+	}
+}
@@ -0,0 +1,135 @@
+package workerpool
+
+import (
+	"context"
+	"errors"
+)
+
+// QueuePolicy decides what happens to a task added while the queue is full
+type QueuePolicy string
+
+const (
+	// PolicyBlock waits for room in the queue until the context is done
+	PolicyBlock QueuePolicy = "block"
+	// PolicyReject returns ErrQueueFull right away
+	PolicyReject QueuePolicy = "reject"
+	// PolicyDropOldest discards the task waiting the longest to make room
+	PolicyDropOldest QueuePolicy = "drop-oldest"
+)
+
+func (p QueuePolicy) valid() bool {
+	switch p {
+	case PolicyBlock, PolicyReject, PolicyDropOldest:
+		return true
+	}
+
+	return false
+}
+
+var (
+	// ErrQueueFull is returned for a task rejected because the queue is full
+	ErrQueueFull = errors.New("worker pool queue is full")
+	// ErrTaskDropped is the result of a queued task discarded by PolicyDropOldest
+	ErrTaskDropped = errors.New("task dropped from a full worker pool queue")
+	// ErrPoolStopped is returned for a task added after the pool was stopped
+	ErrPoolStopped = errors.New("worker pool is stopped")
+)
+
+// AddTask adds a task to the queue of the pool, applying the queue policy
+// if it is full. With PolicyBlock it waits until ctx is done.
+// A task that is not queued is never processed and its Done channel stays open.
+func (p *Pool) AddTask(ctx context.Context, task *Task) error {
+	return p.enqueue(ctx, task, p.policy)
+}
+
+// TryAddTask adds a task without waiting. If the queue is full the task is
+// rejected with ErrQueueFull, unless the policy is PolicyDropOldest.
+func (p *Pool) TryAddTask(task *Task) error {
+	policy := p.policy
+	if policy == PolicyBlock {
+		policy = PolicyReject
+	}
+
+	return p.enqueue(context.Background(), task, policy)
+}
+
+func (p *Pool) enqueue(ctx context.Context, task *Task, policy QueuePolicy) error {
+	if p.ctx.Err() != nil {
+		return ErrPoolStopped
+	}
+
+	p.taskAdded()
+	task.done = p.taskDone
+	queueDepth.Inc()
+
+	select {
+	case p.collector <- task:
+		return nil
+	default:
+	}
+
+	var err error
+	switch policy {
+	case PolicyReject:
+		err = ErrQueueFull
+	case PolicyDropOldest:
+		for {
+			select {
+			case p.collector <- task:
+				return nil
+			default:
+			}
+
+			select {
+			case old := <-p.collector:
+				p.drop(old)
+			default:
+			}
+		}
+	default:
+		select {
+		case p.collector <- task:
+			return nil
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-p.ctx.Done():
+			err = ErrPoolStopped
+		}
+	}
+
+	queueDepth.Dec()
+	tasksRejected.WithLabelValues(string(policy)).Inc()
+	task.done = nil
+	p.taskDone()
+
+	return err
+}
+
+// drop finishes a task taken off the queue without processing it
+func (p *Pool) drop(task *Task) {
+	queueDepth.Dec()
+	tasksRejected.WithLabelValues(string(PolicyDropOldest)).Inc()
+	task.finish(Result{Err: ErrTaskDropped})
+	if task.done != nil {
+		task.done()
+	}
+}
+
+// QueueDepth returns the number of tasks waiting for a worker
+func (p *Pool) QueueDepth() int {
+	return len(p.collector)
+}
+
+// QueueCapacity returns the number of tasks that can wait for a worker
+func (p *Pool) QueueCapacity() int {
+	return cap(p.collector)
+}
+
+// Available returns the number of tasks the queue can take right now
+func (p *Pool) Available() int {
+	if p.ctx.Err() != nil {
+		return 0
+	}
+
+	return cap(p.collector) - len(p.collector)
+}
@@ -0,0 +1,153 @@
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type nopLog struct{}
+
+func (nopLog) Debug(string, ...zapcore.Field) {}
+func (nopLog) Info(string, ...zapcore.Field)  {}
+func (nopLog) Error(string, ...zapcore.Field) {}
+
+func option(v string) func() string {
+	return func() string { return v }
+}
+
+// newTestPool creates a pool whose workers are not started, so queued tasks stay queued
+func newTestPool(capacity string, policy QueuePolicy) *Pool {
+	return NewPool(nil, option("1"), nopLog{}, option("1000"), option("1s"), option(capacity), option(string(policy)))
+}
+
+func newTestTask(data int) *Task {
+	return NewTask(context.Background(), func(context.Context, interface{}) (interface{}, error) {
+		return nil, nil
+	}, data)
+}
+
+func TestQueuePolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  QueuePolicy
+		try     bool
+		wantErr error
+		// dropped is the index of the queued task that is evicted, -1 if none
+		dropped int
+		depth   int
+	}{
+		{name: "block", policy: PolicyBlock, wantErr: context.DeadlineExceeded, dropped: -1, depth: 2},
+		{name: "reject", policy: PolicyReject, wantErr: ErrQueueFull, dropped: -1, depth: 2},
+		{name: "drop oldest", policy: PolicyDropOldest, dropped: 0, depth: 2},
+		{name: "try block", policy: PolicyBlock, try: true, wantErr: ErrQueueFull, dropped: -1, depth: 2},
+		{name: "try reject", policy: PolicyReject, try: true, wantErr: ErrQueueFull, dropped: -1, depth: 2},
+		{name: "try drop oldest", policy: PolicyDropOldest, try: true, dropped: 0, depth: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPool("2", tt.policy)
+
+			queued := []*Task{newTestTask(1), newTestTask(2)}
+			for _, task := range queued {
+				if err := p.AddTask(context.Background(), task); err != nil {
+					t.Fatalf("AddTask() with room in the queue error = %v", err)
+				}
+			}
+			if p.Available() != 0 {
+				t.Fatalf("Available() = %d, want 0", p.Available())
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+
+			extra := newTestTask(3)
+			var err error
+			if tt.try {
+				err = p.TryAddTask(extra)
+			} else {
+				err = p.AddTask(ctx, extra)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("adding to a full queue error = %v, want %v", err, tt.wantErr)
+			}
+			if p.QueueDepth() != tt.depth {
+				t.Errorf("QueueDepth() = %d, want %d", p.QueueDepth(), tt.depth)
+			}
+
+			for i, task := range queued {
+				select {
+				case <-task.Done():
+					if i != tt.dropped {
+						t.Fatalf("task %d finished, it should still be queued", i)
+					}
+					res, err := task.Wait(context.Background())
+					if err != nil || !errors.Is(res.Err, ErrTaskDropped) {
+						t.Errorf("Wait() of the dropped task = %+v, %v, want %v", res, err, ErrTaskDropped)
+					}
+				default:
+					if i == tt.dropped {
+						t.Fatalf("task %d is still queued, it should have been dropped", i)
+					}
+				}
+			}
+
+			// a task that was not queued is never finished
+			if tt.wantErr != nil {
+				if _, err := extra.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("Wait() of the rejected task error = %v, want %v", err, context.DeadlineExceeded)
+				}
+			}
+		})
+	}
+}
+
+func TestAddTaskStopped(t *testing.T) {
+	p := newTestPool("2", PolicyBlock)
+	p.cancelFunc()
+
+	if err := p.AddTask(context.Background(), newTestTask(1)); !errors.Is(err, ErrPoolStopped) {
+		t.Errorf("AddTask() after Stop error = %v, want %v", err, ErrPoolStopped)
+	}
+	if p.Available() != 0 {
+		t.Errorf("Available() after Stop = %d, want 0", p.Available())
+	}
+}
+
+// TestDrainWhileAdding checks that tasks can be added while Drain is waiting
+func TestDrainWhileAdding(t *testing.T) {
+	p := newTestPool("100", PolicyBlock)
+	go p.RunBackground()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			if err := p.AddTask(ctx, newTestTask(i)); err != nil {
+				t.Errorf("AddTask() error = %v", err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		if err := p.Drain(ctx); err != nil {
+			t.Fatalf("Drain() error = %v", err)
+		}
+	}
+	<-done
+
+	if err := p.Drain(ctx); err != nil {
+		t.Fatalf("Drain() error = %v", err)
+	}
+	if p.QueueDepth() != 0 {
+		t.Errorf("QueueDepth() after Drain = %d, want 0", p.QueueDepth())
+	}
+}
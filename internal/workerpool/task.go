@@ -1,20 +1,147 @@
 package workerpool
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/wurt83ow/gophstream/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 )
 
+// TaskFunc is the work of a task. ctx holds the span of the task and is
+// cancelled when the task times out or the pool is stopped, so long running
+// work has to watch it. The returned value is passed on in the Result.
+type TaskFunc func(ctx context.Context, data interface{}) (interface{}, error)
+
+// Result is the outcome of a processed task
+type Result struct {
+	Value    interface{}
+	Err      error
+	Duration time.Duration
+}
+
+// ErrTaskTimeout is wrapped by the error of a task that ran past its timeout
+var ErrTaskTimeout = errors.New("task timed out")
+
+// PanicError is the error of a task that panicked
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("task panicked: %v", e.Value)
+}
+
 type Task struct {
-	Err  error
 	Data interface{}
-	f    func(interface{}) error
+	// Timeout bounds the execution of the task, zero uses the timeout of the pool
+	Timeout time.Duration
+
+	f   TaskFunc
+	ctx context.Context
+	res Result
+	// finished is closed once res is set
+	finished chan struct{}
+	// done is called once the task was processed, to let the pool drain
+	done func()
+}
+
+// NewTask creates a task running f with data. The context of the task is
+// derived from ctx, so its span is a child of the span in ctx and the task
+// is cancelled together with ctx.
+func NewTask(ctx context.Context, f TaskFunc, data interface{}) *Task {
+	return &Task{f: f, Data: data, ctx: ctx, finished: make(chan struct{})}
+}
+
+// Done returns a channel that is closed once the task was processed
+func (t *Task) Done() <-chan struct{} {
+	return t.finished
+}
+
+// Result returns the outcome of the task, it is only set once Done is closed
+func (t *Task) Result() Result {
+	return t.res
 }
 
-func NewTask(f func(interface{}) error, data interface{}) *Task {
-	return &Task{f: f, Data: data}
+// Wait blocks until the task was processed and returns its result,
+// or returns the error of ctx if it is done first
+func (t *Task) Wait(ctx context.Context) (Result, error) {
+	select {
+	case <-t.finished:
+		return t.res, nil
+	case <-ctx.Done():
+		return Result{}, ctx.Err()
+	}
 }
 
-func process(workerID int, task *Task) {
-	fmt.Printf("Worker %d processes task %v\n", workerID, task.Data)
-	task.Err = task.f(task.Data)
+// finish sets the result of the task and closes its Done channel
+func (t *Task) finish(res Result) {
+	t.res = res
+	close(t.finished)
+}
+
+// run calls the function of the task, converting a panic into an error
+func (t *Task) run(ctx context.Context) (value interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+
+	return t.f(ctx, t.Data)
+}
+
+// process runs a task within its timeout, cancelling it if the pool is stopped,
+// and publishes its result
+func (p *Pool) process(workerID int, task *Task) {
+	queueDepth.Dec()
+	activeWorkers.Inc()
+	defer activeWorkers.Dec()
+	if task.done != nil {
+		defer task.done()
+	}
+
+	timeout := task.Timeout
+	if timeout <= 0 {
+		timeout = p.taskTimeout
+	}
+	ctx, cancel := context.WithTimeout(task.ctx, timeout)
+	defer cancel()
+	stop := context.AfterFunc(p.ctx, cancel)
+	defer stop()
+
+	ctx, span := tracing.Tracer().Start(ctx, "workerpool task",
+		trace.WithAttributes(attribute.Int("workerpool.worker_id", workerID)))
+	defer span.End()
+
+	p.log.Debug("worker processes task", zap.Int("workerID", workerID))
+	start := time.Now()
+	value, err := task.run(ctx)
+	elapsed := time.Since(start)
+	taskDuration.Observe(elapsed.Seconds())
+
+	result := "ok"
+	var panicErr *PanicError
+	switch {
+	case errors.As(err, &panicErr):
+		result = "panic"
+		p.log.Error("worker recovered from a panic in a task", zap.Int("workerID", workerID),
+			zap.Any("panic", panicErr.Value), zap.ByteString("stack", panicErr.Stack))
+		span.SetAttributes(attribute.String("exception.stacktrace", string(panicErr.Stack)))
+	case err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded):
+		result = "timeout"
+		err = fmt.Errorf("%w after %s: %w", ErrTaskTimeout, timeout, err)
+	case err != nil:
+		result = "error"
+	}
+	tracing.RecordError(span, err)
+	tasksProcessed.WithLabelValues(result).Inc()
+
+	task.finish(Result{Value: value, Err: err, Duration: elapsed})
 }
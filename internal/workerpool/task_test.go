@@ -0,0 +1,116 @@
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitForCancel is a task that runs until its context is done
+func waitForCancel(ctx context.Context, _ interface{}) (interface{}, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		name    string
+		f       TaskFunc
+		timeout time.Duration
+		// cancel cancels the context of the task or the pool once it runs
+		cancel    func(task, pool context.CancelFunc)
+		wantValue interface{}
+		check     func(t *testing.T, err error)
+	}{
+		{
+			name: "ok",
+			f: func(context.Context, interface{}) (interface{}, error) {
+				return "done", nil
+			},
+			wantValue: "done",
+			check: func(t *testing.T, err error) {
+				if err != nil {
+					t.Errorf("Err = %v, want nil", err)
+				}
+			},
+		},
+		{
+			name:    "timeout",
+			f:       waitForCancel,
+			timeout: 10 * time.Millisecond,
+			check: func(t *testing.T, err error) {
+				if !errors.Is(err, ErrTaskTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("Err = %v, want %v wrapping %v", err, ErrTaskTimeout, context.DeadlineExceeded)
+				}
+			},
+		},
+		{
+			name: "panic",
+			f: func(context.Context, interface{}) (interface{}, error) {
+				panic("boom")
+			},
+			check: func(t *testing.T, err error) {
+				var panicErr *PanicError
+				if !errors.As(err, &panicErr) {
+					t.Fatalf("Err = %v, want a *PanicError", err)
+				}
+				if panicErr.Value != "boom" || len(panicErr.Stack) == 0 {
+					t.Errorf("PanicError = %v with a stack of %d bytes, want boom with a stack", panicErr.Value, len(panicErr.Stack))
+				}
+			},
+		},
+		{
+			name:   "task context cancelled",
+			f:      waitForCancel,
+			cancel: func(task, _ context.CancelFunc) { task() },
+			check: func(t *testing.T, err error) {
+				if !errors.Is(err, context.Canceled) || errors.Is(err, ErrTaskTimeout) {
+					t.Errorf("Err = %v, want %v", err, context.Canceled)
+				}
+			},
+		},
+		{
+			name:   "pool stopped",
+			f:      waitForCancel,
+			cancel: func(_, pool context.CancelFunc) { pool() },
+			check: func(t *testing.T, err error) {
+				if !errors.Is(err, context.Canceled) || errors.Is(err, ErrTaskTimeout) {
+					t.Errorf("Err = %v, want %v", err, context.Canceled)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPool("10", PolicyBlock)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			started := make(chan struct{})
+			task := NewTask(ctx, func(ctx context.Context, data interface{}) (interface{}, error) {
+				close(started)
+				return tt.f(ctx, data)
+			}, nil)
+			task.Timeout = tt.timeout
+
+			go p.process(1, task)
+			<-started
+			if tt.cancel != nil {
+				tt.cancel(cancel, p.cancelFunc)
+			}
+
+			waitCtx, cancelWait := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancelWait()
+			res, err := task.Wait(waitCtx)
+			if err != nil {
+				t.Fatalf("Wait() error = %v", err)
+			}
+			if res.Value != tt.wantValue {
+				t.Errorf("Value = %v, want %v", res.Value, tt.wantValue)
+			}
+			tt.check(t, res.Err)
+		})
+	}
+}
@@ -8,15 +8,17 @@ import (
 // Worker controls all work.
 type Worker struct {
 	ID       int
+	pool     *Pool
 	taskChan chan *Task
 	quit     chan bool
 }
 
-// NewWorker returns a new worker instance.
-func NewWorker(channel chan *Task, ID int) *Worker {
+// NewWorker returns a new worker processing the tasks of the pool.
+func NewWorker(pool *Pool, ID int) *Worker {
 	return &Worker{
 		ID:       ID,
-		taskChan: channel,
+		pool:     pool,
+		taskChan: pool.collector,
 		quit:     make(chan bool),
 	}
 }
@@ -29,7 +31,7 @@ func (wr *Worker) Start(wg *sync.WaitGroup) {
 	go func() {
 		defer wg.Done()
 		for task := range wr.taskChan {
-			process(wr.ID, task)
+			wr.pool.process(wr.ID, task)
 		}
 	}()
 }
@@ -41,7 +43,7 @@ func (wr *Worker) StartBackground() {
 	for {
 		select {
 		case task := <-wr.taskChan:
-			process(wr.ID, task)
+			wr.pool.process(wr.ID, task)
 		case <-wr.quit:
 			return
 		}
@@ -0,0 +1,5 @@
+-- Drop indexes for the messages table
+DROP INDEX IF EXISTS idx_messages_processed;
+
+-- Drop the messages table
+DROP TABLE IF EXISTS messages;
\ No newline at end of file
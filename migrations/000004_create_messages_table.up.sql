@@ -0,0 +1,11 @@
+-- Messages table
+CREATE TABLE messages (
+    id SERIAL PRIMARY KEY,
+    content TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    processed BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+-- Indexes for the messages table
+-- Used by: GetMessages
+CREATE INDEX idx_messages_processed ON messages (processed);
@@ -0,0 +1,6 @@
+-- Drop indexes for the outbox table
+DROP INDEX IF EXISTS idx_outbox_message;
+DROP INDEX IF EXISTS idx_outbox_state_lease;
+
+-- Drop the outbox table
+DROP TABLE IF EXISTS outbox;
\ No newline at end of file
@@ -0,0 +1,22 @@
+-- Outbox table
+-- Every row is written in the same transaction as the message it belongs to
+-- and is delivered to Kafka by the relay in ApiService.
+CREATE TABLE outbox (
+    id BIGSERIAL PRIMARY KEY,
+    message_id INTEGER NOT NULL,
+    payload JSONB NOT NULL,
+    state VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    claimed_by VARCHAR(255),
+    lease_until TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP WITH TIME ZONE,
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    CHECK (state IN ('pending', 'claimed', 'delivered'))
+);
+
+-- Indexes for the outbox table
+-- Used by: ClaimOutbox
+CREATE INDEX idx_outbox_state_lease ON outbox (state, lease_until);
+-- Used by: MarkOutboxDelivered
+CREATE INDEX idx_outbox_message ON outbox (message_id);
@@ -0,0 +1,5 @@
+-- Drop indexes for the message_receipts table
+DROP INDEX IF EXISTS idx_message_receipts_message;
+
+-- Drop the message_receipts table
+DROP TABLE IF EXISTS message_receipts;
\ No newline at end of file
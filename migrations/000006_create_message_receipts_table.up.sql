@@ -0,0 +1,16 @@
+-- Message receipts table
+-- A receipt is written by the Kafka consumer for every message it reads back from the topic
+CREATE TABLE message_receipts (
+    id BIGSERIAL PRIMARY KEY,
+    message_id INTEGER NOT NULL,
+    topic VARCHAR(255) NOT NULL,
+    kafka_partition INTEGER NOT NULL,
+    kafka_offset BIGINT NOT NULL,
+    consumer_group VARCHAR(255) NOT NULL,
+    consumed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (consumer_group, topic, kafka_partition, kafka_offset)
+);
+
+-- Indexes for the message_receipts table
+-- Used by: InsertReceipt
+CREATE INDEX idx_message_receipts_message ON message_receipts (message_id);
@@ -0,0 +1,15 @@
+-- Drop indexes for the messages status
+DROP INDEX IF EXISTS idx_messages_status;
+
+ALTER TABLE messages ADD COLUMN processed BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE messages SET processed = TRUE WHERE status = 'sent';
+CREATE INDEX idx_messages_processed ON messages (processed);
+
+ALTER TABLE messages
+    DROP CONSTRAINT IF EXISTS chk_messages_status,
+    DROP COLUMN status,
+    DROP COLUMN attempts,
+    DROP COLUMN last_error,
+    DROP COLUMN sent_at,
+    DROP COLUMN kafka_partition,
+    DROP COLUMN kafka_offset;
\ No newline at end of file
@@ -0,0 +1,20 @@
+-- Delivery status of messages
+ALTER TABLE messages
+    ADD COLUMN status VARCHAR(16) NOT NULL DEFAULT 'queued',
+    ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN last_error TEXT,
+    ADD COLUMN sent_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN kafka_partition INTEGER,
+    ADD COLUMN kafka_offset BIGINT;
+
+UPDATE messages SET status = 'sent' WHERE processed;
+
+ALTER TABLE messages
+    ADD CONSTRAINT chk_messages_status CHECK (status IN ('queued', 'in_flight', 'sent', 'failed', 'dead'));
+
+DROP INDEX IF EXISTS idx_messages_processed;
+ALTER TABLE messages DROP COLUMN processed;
+
+-- Indexes for the messages table
+-- Used by: GetMessages
+CREATE INDEX idx_messages_status ON messages (status);
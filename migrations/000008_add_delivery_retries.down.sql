@@ -0,0 +1,13 @@
+-- Drop indexes for the outbox retries
+DROP INDEX IF EXISTS idx_outbox_pending_available;
+
+ALTER TABLE messages DROP COLUMN error_class;
+
+UPDATE outbox SET state = 'delivered' WHERE state = 'dead';
+ALTER TABLE outbox DROP CONSTRAINT IF EXISTS outbox_state_check;
+ALTER TABLE outbox
+    ADD CONSTRAINT outbox_state_check CHECK (state IN ('pending', 'claimed', 'delivered'));
+
+ALTER TABLE outbox
+    DROP COLUMN available_at,
+    DROP COLUMN last_error;
\ No newline at end of file
@@ -0,0 +1,15 @@
+-- Retry scheduling for the outbox table
+ALTER TABLE outbox
+    ADD COLUMN available_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ADD COLUMN last_error TEXT;
+
+ALTER TABLE outbox DROP CONSTRAINT IF EXISTS outbox_state_check;
+ALTER TABLE outbox
+    ADD CONSTRAINT outbox_state_check CHECK (state IN ('pending', 'claimed', 'delivered', 'dead'));
+
+-- Classification of the last delivery error of a message
+ALTER TABLE messages ADD COLUMN error_class VARCHAR(32);
+
+-- Indexes for the outbox table
+-- Used by: ClaimOutbox
+CREATE INDEX idx_outbox_pending_available ON outbox (available_at) WHERE state = 'pending';
@@ -0,0 +1,3 @@
+ALTER TABLE messages
+    DROP COLUMN topic,
+    DROP COLUMN type;
\ No newline at end of file
@@ -0,0 +1,4 @@
+-- Routing attributes of messages
+ALTER TABLE messages
+    ADD COLUMN topic VARCHAR(255),
+    ADD COLUMN type VARCHAR(255);
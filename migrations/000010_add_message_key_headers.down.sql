@@ -0,0 +1,3 @@
+ALTER TABLE messages
+    DROP COLUMN partition_key,
+    DROP COLUMN headers;
\ No newline at end of file
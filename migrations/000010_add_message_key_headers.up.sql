@@ -0,0 +1,4 @@
+-- Partition key and Kafka headers of messages
+ALTER TABLE messages
+    ADD COLUMN partition_key TEXT,
+    ADD COLUMN headers JSONB;
@@ -0,0 +1,5 @@
+-- Drop indexes for the idempotency_keys table
+DROP INDEX IF EXISTS idx_idempotency_keys_expires;
+
+-- Drop the idempotency_keys table
+DROP TABLE IF EXISTS idempotency_keys;
\ No newline at end of file
@@ -0,0 +1,15 @@
+-- Idempotency keys table
+-- Stores the response of POST /api/message for every Idempotency-Key until it expires
+CREATE TABLE idempotency_keys (
+    key VARCHAR(255) PRIMARY KEY,
+    request_hash VARCHAR(64) NOT NULL,
+    message_id INTEGER REFERENCES messages(id) ON DELETE CASCADE,
+    status_code INTEGER NOT NULL,
+    response BYTEA,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Indexes for the idempotency_keys table
+-- Used by: InsertMessage (purge of expired keys)
+CREATE INDEX idx_idempotency_keys_expires ON idempotency_keys (expires_at);
@@ -0,0 +1,6 @@
+-- Drop indexes for the messages table
+DROP INDEX IF EXISTS idx_messages_created_at;
+DROP INDEX IF EXISTS idx_messages_sent_at;
+DROP INDEX IF EXISTS idx_messages_topic;
+DROP INDEX IF EXISTS idx_messages_headers;
+DROP INDEX IF EXISTS idx_messages_content_fts;
\ No newline at end of file
@@ -0,0 +1,11 @@
+-- Indexes for the messages table
+-- Used by: GetMessages (created_at range and sort)
+CREATE INDEX idx_messages_created_at ON messages (created_at, id);
+-- Used by: GetMessages (sent_at sort)
+CREATE INDEX idx_messages_sent_at ON messages (sent_at, id);
+-- Used by: GetMessages (topic filter)
+CREATE INDEX idx_messages_topic ON messages (topic);
+-- Used by: GetMessages (header match)
+CREATE INDEX idx_messages_headers ON messages USING GIN (headers jsonb_path_ops);
+-- Used by: GetMessages (full-text search)
+CREATE INDEX idx_messages_content_fts ON messages USING GIN (to_tsvector('simple', content));
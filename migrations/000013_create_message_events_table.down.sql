@@ -0,0 +1,9 @@
+-- Drop the trigger recording message events
+DROP TRIGGER IF EXISTS trg_messages_status_event ON messages;
+DROP FUNCTION IF EXISTS record_message_event();
+
+-- Drop indexes for the message_events table
+DROP INDEX IF EXISTS idx_message_events_created_at;
+
+-- Drop the message_events table
+DROP TABLE IF EXISTS message_events;
\ No newline at end of file
@@ -0,0 +1,47 @@
+-- Message events table
+-- Records every time a message is sent or fails, for the live feed of status changes
+CREATE TABLE message_events (
+    id BIGSERIAL PRIMARY KEY,
+    message_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    status VARCHAR(16) NOT NULL,
+    error TEXT,
+    error_class VARCHAR(32),
+    kafka_partition INTEGER,
+    kafka_offset BIGINT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Records the event and notifies the listeners of all instances with it.
+-- The error is shortened to keep the payload within the 8000 byte limit of NOTIFY.
+CREATE FUNCTION record_message_event() RETURNS trigger AS $$
+DECLARE
+    event message_events;
+BEGIN
+    INSERT INTO message_events (message_id, status, error, error_class, kafka_partition, kafka_offset)
+    VALUES (NEW.id, NEW.status, NEW.last_error, NEW.error_class, NEW.kafka_partition, NEW.kafka_offset)
+    RETURNING * INTO event;
+
+    PERFORM pg_notify('message_events', json_build_object(
+        'id', event.id,
+        'message_id', event.message_id,
+        'status', event.status,
+        'error', left(event.error, 1000),
+        'error_class', event.error_class,
+        'kafka_partition', event.kafka_partition,
+        'kafka_offset', event.kafka_offset,
+        'created_at', event.created_at
+    )::text);
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_messages_status_event
+    AFTER UPDATE OF status ON messages
+    FOR EACH ROW
+    WHEN (NEW.status IN ('sent', 'failed', 'dead') AND NEW.status IS DISTINCT FROM OLD.status)
+    EXECUTE FUNCTION record_message_event();
+
+-- Indexes for the message_events table
+-- Used by: PurgeMessageEvents
+CREATE INDEX idx_message_events_created_at ON message_events (created_at);
@@ -0,0 +1,4 @@
+-- W3C traceparent of the request that created the message,
+-- continued when the message is published to Kafka
+ALTER TABLE messages
+    ADD COLUMN trace_parent VARCHAR(55);
@@ -0,0 +1,4 @@
+-- Trigram index for the substring filter, a B-tree index cannot serve ILIKE '%...%'
+-- Used by: GetMessages (contains filter)
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX idx_messages_content_trgm ON messages USING GIN (content gin_trgm_ops);